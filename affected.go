@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runAffectedTests implements "fullcover affected-tests": it prints the
+// "go test -run" pattern selecting the tests executing lines changed by a diff.
+func runAffectedTests(args []string) {
+	flags := flag.NewFlagSet("affected-tests", flag.ExitOnError)
+	daemonAddress := flags.String("connection", "", "how to reach the sidechannel daemon")
+	sessionName := flags.String("session", "", "session holding the per-test coverage, default is the current one")
+	diffFile := flags.String("diff", "", "read the unified diff from this file (- for stdin) instead of running git diff")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage of 'go tool fullcover affected-tests':
+Print the go test -run pattern of the tests executing lines changed since a commit (default HEAD)
+	go test -run "$(go tool fullcover affected-tests -connection 'localhost:10001' [commit])" ./...`)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *daemonAddress == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Paths in diffs of git are relative to the top of the repository.
+	root := ""
+	if output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+		root = strings.TrimSpace(string(output))
+	}
+
+	var diff io.Reader
+	switch *diffFile {
+	case "":
+		gitArgs := append([]string{"diff", "-U0"}, flags.Args()...)
+		if flags.NArg() == 0 {
+			gitArgs = append(gitArgs, "HEAD")
+		}
+
+		output, err := exec.Command("git", gitArgs...).Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: git diff: %v\n", err)
+			os.Exit(1)
+		}
+		diff = strings.NewReader(string(output))
+	case "-":
+		diff = os.Stdin
+	default:
+		f, err := os.Open(*diffFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		diff = f
+	}
+
+	changes, err := parseDiff(diff)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+	if *diffFile == "" {
+		if err := addUntracked(changes, root); err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	resp, err := http.Get("http://" + *daemonAddress + "/tests/blocks?session=" + url.QueryEscape(*sessionName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+
+	var coverage testCoverage
+	err = json.NewDecoder(resp.Body).Decode(&coverage)
+	resp.Body.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: invalid test coverage: %v\n", err)
+		os.Exit(1)
+	}
+
+	tests, all := affectedTests(changes, root, coverage)
+	if all {
+		fmt.Println(".")
+		return
+	}
+	fmt.Println(testPattern(tests))
+}
+
+// fileChange collects what a diff changes in one file.
+type fileChange struct {
+	lines []lineRange // changed lines, numbered as before the change
+	tests []string    // test functions added
+}
+
+// lineRange is an inclusive range of line numbers.
+type lineRange struct {
+	from, to int
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+var addedTest = regexp.MustCompile(`^\+func (Test\w*)\(`)
+
+// parseDiff reads a unified diff into the changes per file, keyed by the path
+// before the change (after the change for new files).
+func parseDiff(r io.Reader) (map[string]*fileChange, error) {
+	changes := make(map[string]*fileChange)
+	var current *fileChange
+	oldPath := ""
+	oldLeft, newLeft := 0, 0 // lines of the current hunk still to come
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case oldLeft > 0 || newLeft > 0:
+			switch {
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+				if m := addedTest.FindStringSubmatch(line); m != nil {
+					current.tests = append(current.tests, m[1])
+				}
+			case strings.HasPrefix(line, " "):
+				oldLeft--
+				newLeft--
+			}
+
+		case strings.HasPrefix(line, "--- "):
+			oldPath = diffPath(line[4:])
+
+		case strings.HasPrefix(line, "+++ "):
+			path := oldPath
+			if path == "" {
+				path = diffPath(line[4:])
+			}
+
+			if changes[path] == nil {
+				changes[path] = &fileChange{}
+			}
+			current = changes[path]
+
+		case strings.HasPrefix(line, "@@ "):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil || current == nil {
+				return nil, fmt.Errorf("invalid diff hunk %q", line)
+			}
+
+			start, _ := strconv.Atoi(m[1])
+			count := hunkCount(m[2])
+			oldLeft, newLeft = count, hunkCount(m[3])
+
+			if count == 0 {
+				// Pure insertion after line start: the code around it is affected.
+				current.lines = append(current.lines, lineRange{start, start + 1})
+			} else {
+				current.lines = append(current.lines, lineRange{start, start + count - 1})
+			}
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// hunkCount parses the optional line count of a hunk header, which defaults to 1.
+func hunkCount(count string) int {
+	if count == "" {
+		return 1
+	}
+
+	n, _ := strconv.Atoi(count)
+	return n
+}
+
+// diffPath strips the a/ or b/ prefix of a path in a diff header, returning ""
+// for /dev/null.
+func diffPath(path string) string {
+	if tab := strings.IndexByte(path, '\t'); tab >= 0 {
+		path = path[:tab]
+	}
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// addUntracked adds the files git does not track yet, which git diff leaves
+// out, to changes as new files.
+func addUntracked(changes map[string]*fileChange, root string) error {
+	list := exec.Command("git", "ls-files", "--others", "--exclude-standard", "-z")
+	list.Dir = root
+	output, err := list.Output()
+	if err != nil {
+		return fmt.Errorf("git ls-files: %v", err)
+	}
+
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path == "" || changes[path] != nil {
+			continue
+		}
+
+		change := &fileChange{}
+		if strings.HasSuffix(path, "_test.go") {
+			source, err := ioutil.ReadFile(filepath.Join(root, path))
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(source), "\n") {
+				if m := addedTest.FindStringSubmatch("+" + line); m != nil {
+					change.tests = append(change.tests, m[1])
+				}
+			}
+		}
+		changes[path] = change
+	}
+	return nil
+}
+
+// matchSource finds the daemon's name of the file at path, relative to the
+// repository at root. Sources are usually reported under their absolute path.
+// Otherwise a name which is more or less qualified than path matches, unless
+// several names do.
+func matchSource(path, root string, files []string) (string, bool) {
+	absolute := ""
+	if root != "" {
+		absolute = filepath.ToSlash(filepath.Join(root, path))
+	}
+
+	var candidates []string
+	for _, file := range files {
+		if file == absolute || file == path {
+			return file, true
+		}
+		if strings.HasSuffix(file, "/"+path) || strings.HasSuffix(path, "/"+file) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) != 1 {
+		return "", false
+	}
+	return candidates[0], true
+}
+
+// affectedTests returns the top-level tests executing changed lines or added by
+// the change, or all if some changed Go file has no coverage data. Other files
+// without coverage data, such as documentation, do not select any tests.
+func affectedTests(changes map[string]*fileChange, root string, coverage testCoverage) (tests []string, all bool) {
+	selected := make(map[string]bool)
+
+	for path, change := range changes {
+		for _, test := range change.tests {
+			selected[test] = true
+		}
+
+		file, ok := matchSource(path, root, coverage.Files)
+		if !ok && !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "fullcover: no coverage data for %s, selecting all tests\n", path)
+			return nil, true
+		}
+
+		for test, blocks := range coverage.Tests {
+			for _, b := range blocks {
+				if b.File == file && overlaps(change.lines, b.StartLine, b.EndLine) {
+					selected[strings.SplitN(test, "/", 2)[0]] = true
+					break
+				}
+			}
+		}
+	}
+
+	for test := range selected {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	return tests, false
+}
+
+// overlaps reports whether any of ranges intersects the lines from to to.
+func overlaps(ranges []lineRange, from, to int) bool {
+	for _, r := range ranges {
+		if r.from <= to && from <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// testPattern returns the "go test -run" pattern matching exactly the named
+// top-level tests, or no test at all.
+func testPattern(tests []string) string {
+	if len(tests) == 0 {
+		return "^$"
+	}
+
+	var quoted []string
+	for _, test := range tests {
+		quoted = append(quoted, regexp.QuoteMeta(test))
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The JSON API is versioned by its path prefix. Fields are only ever added
+// to a version, never changed or removed.
+const apiPrefix = "/api/v1/"
+
+type apiCoverage struct {
+	CoveredStatements int `json:"coveredStatements"`
+	TotalStatements   int `json:"totalStatements"`
+	CoveredBlocks     int `json:"coveredBlocks"`
+	TotalBlocks       int `json:"totalBlocks"`
+}
+
+type apiFile struct {
+	File string `json:"file"`
+	apiCoverage
+}
+
+type apiFiles struct {
+	Session string      `json:"session"`
+	Scope   string      `json:"scope,omitempty"`
+	Test    string      `json:"test,omitempty"`
+	Files   []apiFile   `json:"files"`
+	Total   apiCoverage `json:"total"`
+}
+
+type apiBlock struct {
+	StartLine  int `json:"startLine"`
+	StartCol   int `json:"startCol"`
+	EndLine    int `json:"endLine"`
+	EndCol     int `json:"endCol"`
+	Statements int `json:"statements"`
+	Count      int `json:"count"`
+}
+
+type apiBlocks struct {
+	Session string     `json:"session"`
+	Scope   string     `json:"scope,omitempty"`
+	Test    string     `json:"test,omitempty"`
+	File    string     `json:"file"`
+	Blocks  []apiBlock `json:"blocks"`
+}
+
+type apiFunction struct {
+	File              string `json:"file"`
+	Name              string `json:"name"`
+	StartLine         int    `json:"startLine"`
+	StartCol          int    `json:"startCol"`
+	EndLine           int    `json:"endLine"`
+	EndCol            int    `json:"endCol"`
+	CoveredStatements int    `json:"coveredStatements"`
+	TotalStatements   int    `json:"totalStatements"`
+	Calls             int    `json:"calls"`
+}
+
+// apiError is why the functions of a file are missing.
+type apiError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+type apiFunctions struct {
+	Session   string        `json:"session"`
+	Scope     string        `json:"scope,omitempty"`
+	Test      string        `json:"test,omitempty"`
+	Functions []apiFunction `json:"functions"`
+	Errors    []apiError    `json:"errors,omitempty"`
+}
+
+// apiExecutor executed a line count times.
+type apiExecutor struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type apiLine struct {
+	File     string        `json:"file"`
+	Line     int           `json:"line"`
+	Session  string        `json:"session"` // the session scopes and tests are from
+	Sessions []apiExecutor `json:"sessions"`
+	Scopes   []apiExecutor `json:"scopes"`
+	Tests    []apiExecutor `json:"tests"`
+}
+
+// handleAPI serves the JSON API:
+//
+//	GET /api/v1/files                    all files with statement and block totals
+//	GET /api/v1/blocks?file=F            the blocks of file F with execution counts
+//	GET /api/v1/functions[?file=F]       the coverage of every function (of file F)
+//	GET /api/v1/line?file=F&line=L       the sessions, scopes and tests executing line L of F
+//
+// All take the "session" parameter and, except for line, "scope" or "test"
+// restricting the counts, like the HTML views.
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	test := r.URL.Query().Get("test")
+	counts := s.viewCounts(scope, test)
+
+	filename := r.URL.Query().Get("file")
+	if _, ok := sources[filename]; filename != "" && !ok {
+		http.Error(w, "unknown file "+filename, http.StatusNotFound)
+		return
+	}
+
+	var result interface{}
+	switch strings.TrimPrefix(r.URL.Path, apiPrefix) {
+	case "files":
+		files := apiFiles{Session: s.name, Scope: scope, Test: test, Files: []apiFile{}}
+		for _, filename := range sortedFilenames() {
+			c := blockCoverage(counts[filename])
+			files.Files = append(files.Files, apiFile{File: filename, apiCoverage: c})
+
+			files.Total.CoveredStatements += c.CoveredStatements
+			files.Total.TotalStatements += c.TotalStatements
+			files.Total.CoveredBlocks += c.CoveredBlocks
+			files.Total.TotalBlocks += c.TotalBlocks
+		}
+		result = files
+
+	case "blocks":
+		if filename == "" {
+			http.Error(w, "file missing", http.StatusBadRequest)
+			return
+		}
+
+		blocks := apiBlocks{Session: s.name, Scope: scope, Test: test, File: filename, Blocks: []apiBlock{}}
+		for _, b := range sortedBlocks(counts[filename]) {
+			blocks.Blocks = append(blocks.Blocks, apiBlock{
+				StartLine:  b.startLine,
+				StartCol:   b.startCol,
+				EndLine:    b.endLine,
+				EndCol:     b.endCol,
+				Statements: b.numStmt,
+				Count:      b.count,
+			})
+		}
+		result = blocks
+
+	case "functions":
+		filenames := sortedFilenames()
+		if filename != "" {
+			filenames = []string{filename}
+		}
+
+		functions := apiFunctions{Session: s.name, Scope: scope, Test: test, Functions: []apiFunction{}}
+		for _, filename := range filenames {
+			summaries, err := summarizeFunctions(filename, sources[filename], counts[filename])
+			if err != nil {
+				functions.Errors = append(functions.Errors, apiError{File: filename, Error: err.Error()})
+				continue
+			}
+
+			for _, summary := range summaries {
+				functions.Functions = append(functions.Functions, apiFunction{
+					File:              filename,
+					Name:              summary.function.name,
+					StartLine:         summary.function.startLine,
+					StartCol:          summary.function.startCol,
+					EndLine:           summary.function.endLine,
+					EndCol:            summary.function.endCol,
+					CoveredStatements: summary.coveredStmt,
+					TotalStatements:   summary.totalStmt,
+					Calls:             summary.calls,
+				})
+			}
+		}
+		result = functions
+
+	case "line":
+		line, err := strconv.Atoi(r.URL.Query().Get("line"))
+		if filename == "" || err != nil {
+			http.Error(w, "file or line missing", http.StatusBadRequest)
+			return
+		}
+
+		result = apiLine{
+			File:     filename,
+			Line:     line,
+			Session:  s.name,
+			Sessions: sessionsExecuting(filename, line),
+			Scopes:   s.scopes.executing(s.counts[filename], filename, line),
+			Tests:    s.tests.executing(s.counts[filename], filename, line),
+		}
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// blockCoverage sums up the statements and blocks of a file.
+func blockCoverage(fileCounts map[int]map[int]*block) apiCoverage {
+	var c apiCoverage
+	c.CoveredStatements, c.TotalStatements = statementCoverage(fileCounts)
+	for _, lineCounts := range fileCounts {
+		for _, b := range lineCounts {
+			c.TotalBlocks++
+			if b.count > 0 {
+				c.CoveredBlocks++
+			}
+		}
+	}
+	return c
+}
+
+// onLine returns the blocks of a file spanning the line.
+func onLine(fileCounts map[int]map[int]*block, line int) []*block {
+	var result []*block
+	for _, b := range sortedBlocks(fileCounts) {
+		if b.startLine <= line && line <= b.endLine {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// sessionsExecuting lists the sessions executing a line, with the highest
+// count of the blocks spanning it. The caller must hold countsLock.
+func sessionsExecuting(filename string, line int) []apiExecutor {
+	result := []apiExecutor{}
+	for _, name := range sessionNames {
+		max := 0
+		for _, b := range onLine(sessions[name].counts[filename], line) {
+			if b.count > max {
+				max = b.count
+			}
+		}
+
+		if max > 0 {
+			result = append(result, apiExecutor{Name: name, Count: max})
+		}
+	}
+	return result
+}
+
+// executing lists the names of t executing a line, with the highest count of
+// the blocks spanning it.
+func (t tally) executing(fileCounts map[int]map[int]*block, filename string, line int) []apiExecutor {
+	blocks := onLine(fileCounts, line)
+
+	result := []apiExecutor{}
+	for _, name := range t.names() {
+		max := 0
+		for _, b := range blocks {
+			if count := t[name][filename][b.startLine][b.startCol]; count > max {
+				max = count
+			}
+		}
+
+		if max > 0 {
+			result = append(result, apiExecutor{Name: name, Count: max})
+		}
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requestedTotals sums up the statements of the requested session, scope or
+// test the way the index does, restricted to the directory given by the
+// "path" query parameter, if any. The caller must hold countsLock.
+func requestedTotals(r *http.Request) (s *session, node *treeNode, err error) {
+	s = requestedSession(r)
+	if s == nil {
+		return nil, nil, fmt.Errorf("no such session")
+	}
+
+	counts := s.viewCounts(r.URL.Query().Get("scope"), r.URL.Query().Get("test"))
+	node = buildTree(counts, indexFilter{})
+
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	if path == "" {
+		return s, node, nil
+	}
+	for _, dir := range strings.Split(path, "/") {
+		node = node.dirs[dir]
+		if node == nil {
+			return nil, nil, fmt.Errorf("no sources below %s", path)
+		}
+	}
+	return s, node, nil
+}
+
+// handleSummary prints the total coverage in one line on GET /summary.txt, for
+// shell prompts and chat bots.
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s, node, err := requestedTotals(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	name := s.name
+	if path := strings.Trim(r.URL.Query().Get("path"), "/"); path != "" {
+		name += " " + path + "/"
+	}
+	fmt.Fprintf(w, "%s: %s\n", name, formatCoverage(node.coveredStmt, node.totalStmt))
+}
+
+// handleBadge renders the total coverage as an SVG badge on GET /badge.svg. It
+// is red below the percentage given by the "red" query parameter, yellow below
+// "yellow" and green otherwise.
+func handleBadge(w http.ResponseWriter, r *http.Request) {
+	red, yellow := 50.0, 80.0
+	for name, threshold := range map[string]*float64{"red": &red, "yellow": &yellow} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		if *threshold, err = strconv.ParseFloat(value, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s parameter: %v", name, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	countsLock.Lock()
+	_, node, err := requestedTotals(r)
+	countsLock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	value, color := "unknown", "#9f9f9f"
+	if node.totalStmt > 0 {
+		percent := ratio(node.coveredStmt, node.totalStmt) * 100
+		value = fmt.Sprintf("%.1f%%", percent)
+		switch {
+		case percent < red:
+			color = "#e05d44"
+		case percent < yellow:
+			color = "#dfb317"
+		default:
+			color = "#4c1"
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeBadge(w, "coverage", value, color)
+}
+
+// writeBadge draws a badge of label and value, the latter on a background of color.
+func writeBadge(w io.Writer, label, value, color string) {
+	// Verdana at 11px averages about 7px per character.
+	labelWidth := 7*len(label) + 10
+	valueWidth := 7*len(value) + 10
+	width := labelWidth + valueWidth
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+  <title>%[4]s: %[5]s</title>
+  <rect width="%[2]d" height="20" fill="#555"/>
+  <rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]d" y="14">%[4]s</text>
+    <text x="%[8]d" y="14">%[5]s</text>
+  </g>
+</svg>
+`, width, labelWidth, valueWidth, label, value, color, labelWidth/2, labelWidth+valueWidth/2)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// checkpoint records firstCoverages at the time a sender's checkpoint
+// marker arrived, so hits between two markers can be attributed.
+type checkpoint struct {
+	firsts  int
+	arrived time.Time
+}
+
+// checkpoints holds recent checkpoints by id, protected by countsLock.
+// checkpointArrived is closed (and replaced) whenever one arrives.
+var checkpoints = make(map[string]checkpoint)
+var checkpointArrived = make(chan struct{})
+
+// checkpointTimeout is how long checkpoints are kept and waited for.
+const checkpointTimeout = time.Minute
+
+// collectCheckpoint records the arrival of a checkpoint marker.
+func collectCheckpoint(id string) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	now := time.Now()
+	for oldID, c := range checkpoints {
+		if now.Sub(c.arrived) > checkpointTimeout {
+			delete(checkpoints, oldID)
+		}
+	}
+
+	checkpoints[id] = checkpoint{firsts: firstCoverages, arrived: now}
+	close(checkpointArrived)
+	checkpointArrived = make(chan struct{})
+}
+
+// handleCheckpoints reports how many blocks were executed for the first time
+// between the checkpoints given by "from" and "to", waiting for them to arrive.
+func handleCheckpoints(w http.ResponseWriter, r *http.Request) {
+	from := r.FormValue("from")
+	to := r.FormValue("to")
+	timeout := time.After(checkpointTimeout)
+
+	for {
+		countsLock.Lock()
+		fromCheckpoint, fromOk := checkpoints[from]
+		toCheckpoint, toOk := checkpoints[to]
+		arrived := checkpointArrived
+
+		if fromOk && toOk {
+			delete(checkpoints, from)
+			delete(checkpoints, to)
+			countsLock.Unlock()
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintf(w, "%d\n", toCheckpoint.firsts-fromCheckpoint.firsts)
+			return
+		}
+		countsLock.Unlock()
+
+		select {
+		case <-arrived:
+		case <-timeout:
+			http.Error(w, "checkpoints did not arrive", http.StatusGatewayTimeout)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -16,9 +16,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const usageMessage = "" +
@@ -26,27 +26,54 @@ const usageMessage = "" +
 Generate modified source code with coverage annotations
 	go tool fullcover [options] -mode rewrite -connection 'localhost:10001' program.go
 
+Generate modified source code which additionally allows injecting errors
+	go tool fullcover [options] -mode inject -connection 'localhost:10001' program.go
+
+Generate modified source code which additionally contains mutants
+	go tool fullcover [options] -mode mutate -connection 'localhost:10001' program.go
+
+Run tests against each mutant
+	go tool fullcover mutate -connection 'localhost:10001' go test ./...
+
+Select the tests affected by changes since a commit
+	go tool fullcover affected-tests -connection 'localhost:10001' [commit]
+
+Print the collected coverage, failing below thresholds
+	go tool fullcover report -connection 'localhost:10001' -min-total 80
+
+Write the collected coverage as a static HTML report
+	go tool fullcover export -connection 'localhost:10001' -o coverage.html
+
 Collect coverage information and display it
 	go tool fullcover -connection 'localhost:10001' -daemon
 `
 
 func usage() {
-	fmt.Fprintln(os.Stderr, usageMessage)
+	fmt.Fprint(os.Stderr, usageMessage+"\n")
 	fmt.Fprintln(os.Stderr, "Flags:")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 var (
-	mode          = flag.String("mode", "", "coverage mode: remote")
+	mode          = flag.String("mode", "", "coverage mode: remote, inject, mutate")
 	coverCall     = flag.String("coverCall", "", "name of the function to call to count statement execution")
 	blockCall     = flag.String("blockCall", "", "name of the function to call to report existence of a block")
 	sourceCall    = flag.String("sourceCall", "", "name of the function to call to report file sources")
+	injectCall    = flag.String("injectCall", "", "name of the function to call to possibly inject an error")
+	siteCall      = flag.String("siteCall", "", "name of the function to call to report the existence of an injection site")
+	mutantCall    = flag.String("mutantCall", "", "name of the function to call to check whether a mutant is active")
+	mutationCall  = flag.String("mutationCall", "", "name of the function to call to report the existence of a mutant")
+	testCall      = flag.String("testCall", "", "name of the function to call to attribute executions to a running test")
 	output        = flag.String("o", "", "output file")
 	daemon        = flag.Bool("daemon", false, "whether to run as sidechannel daemon")
 	connection    = flag.String("connection", "", "how to reach the sidechannel daemon")
 	allStatements = flag.Bool("allStatements", true, "whether to count each statement separately")
 	sourceName    = flag.String("sourceName", "", "source file name to report to the daemon")
+	fade          = flag.Duration("fade", 2*time.Second, "how long executed blocks stay highlighted in the live source view")
+	snapshotDir   = flag.String("snapshotDir", ".", "directory the daemon stores coverage snapshots in")
+	maxScopes     = flag.Int("maxScopes", 1000, "how many scopes the daemon tallies executions for per session")
+	history       = flag.String("history", "", "file the daemon keeps when each block was first reported and last executed in, across restarts")
 )
 
 const (
@@ -57,7 +84,23 @@ var senderPackageName = "_cover_sender_"
 
 var inputFile string
 
+// commands are run by "fullcover <command> [arguments]".
+var commands = map[string]func(args []string){
+	"mutate":         runMutate,
+	"fuzz":           runFuzz,
+	"affected-tests": runAffectedTests,
+	"report":         runReport,
+	"export":         runExport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -93,6 +136,26 @@ func main() {
 			*sourceCall = fmt.Sprintf("%s.ReportFile", senderPackageName)
 		}
 
+		if *injectCall == "" {
+			*injectCall = fmt.Sprintf("%s.InjectError", senderPackageName)
+		}
+
+		if *siteCall == "" {
+			*siteCall = fmt.Sprintf("%s.ReportInjectionSite", senderPackageName)
+		}
+
+		if *mutantCall == "" {
+			*mutantCall = fmt.Sprintf("%s.Mutant", senderPackageName)
+		}
+
+		if *mutationCall == "" {
+			*mutationCall = fmt.Sprintf("%s.ReportMutant", senderPackageName)
+		}
+
+		if *testCall == "" {
+			*testCall = fmt.Sprintf("%s.EnterTest", senderPackageName)
+		}
+
 		if *sourceName == "" {
 			*sourceName = inputFile
 		}
@@ -116,7 +179,7 @@ func parseFlags() error {
 
 	if *mode != "" {
 		switch *mode {
-		case "remote":
+		case "remote", "inject", "mutate":
 			// ok
 		default:
 			return fmt.Errorf("unknown -mode %v", *mode)
@@ -153,6 +216,8 @@ type File struct {
 	name      string // Name of file.
 	astFile   *ast.File
 	blocks    []Block
+	sites     []injectionSite
+	mutants   []mutation
 	atomicPkg string // Package name for "sync/atomic" in this file.
 }
 
@@ -257,13 +322,12 @@ func generateName(fn string, postfix string) string {
 // addImport adds an import for the specified path, if one does not already exist, and returns
 // the local package name.
 func (f *File) addImport(path string, defaultName string) string {
-	// Does the package already import it?
+	// Does the package already import it under that name? If it is imported
+	// under another name, import it a second time: the calls to generate were
+	// named before the file was parsed.
 	for _, s := range f.astFile.Imports {
-		if unquote(s.Path.Value) == path {
-			if s.Name != nil {
-				return s.Name.Name
-			}
-			return filepath.Base(path)
+		if unquote(s.Path.Value) == path && s.Name != nil && s.Name.Name == defaultName {
+			return defaultName
 		}
 	}
 	newImport := &ast.ImportSpec{
@@ -338,6 +402,15 @@ func annotate(name string) {
 	}
 	senderPackageName = file.addImport(senderPackagePath, senderPackageName)
 	ast.Walk(file, file.astFile)
+	// The remaining passes insert their calls after the counters, so that
+	// these do not show up as blocks themselves.
+	file.addTestEntries()
+	if *mode == "inject" {
+		file.addInjections()
+	}
+	if *mode == "mutate" {
+		file.addMutants()
+	}
 	fd := os.Stdout
 	if *output != "" {
 		var err error
@@ -367,7 +440,7 @@ func trimComments(file *ast.File, fset *token.FileSet) []*ast.CommentGroup {
 			}
 		}
 		if list != nil {
-			comments = append(comments, &ast.CommentGroup{list})
+			comments = append(comments, &ast.CommentGroup{List: list})
 		}
 	}
 	return comments
@@ -649,6 +722,20 @@ func init() {
 `, *blockCall, f.quoteString(*connection), f.quoteString(*sourceName), b.startLine, b.startCol, b.endLine, b.endCol, b.numStmt)
 	}
 
+	// Report all injection sites of this file
+	for _, s := range f.sites {
+		fmt.Fprintf(w, `
+	%s(%s, %s, %d, %d, %s)
+`, *siteCall, f.quoteString(*connection), f.quoteString(*sourceName), s.line, s.col, f.quoteString(s.call))
+	}
+
+	// Report all mutants of this file
+	for _, m := range f.mutants {
+		fmt.Fprintf(w, `
+	%s(%s, %s, %d, %d, %d, %s)
+`, *mutationCall, f.quoteString(*connection), f.quoteString(*sourceName), m.id, m.line, m.col, f.quoteString(m.description))
+	}
+
 	fmt.Fprintf(w, `
 }`)
 }
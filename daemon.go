@@ -9,6 +9,14 @@ import (
 	"io"
 	"strings"
 	"os"
+	"sort"
+	"strconv"
+	"net/url"
+	"time"
+	"math"
+	"html"
+	"unicode/utf8"
+	"sync/atomic"
 )
 
 // sources holds all reported sources
@@ -23,25 +31,89 @@ type block struct {
 	count int
 }
 
-// counts, key is [source][startLine][startCol]
-var counts map[string]map[int]map[int]*block
+// countsLock protects sources and all sessions
 var countsLock sync.Mutex
 
+// blockKey identifies a block independent of any session.
+type blockKey struct {
+	file string
+	line int
+	col  int
+}
+
+// everCovered holds all blocks executed since the daemon started, in any session.
+// firstCoverages counts them, so clients can detect newly covered code.
+var everCovered = make(map[blockKey]bool)
+var firstCoverages int
+
 func runDaemon() {
+	if err := loadHistory(); err != nil {
+		log.Fatalf("history %s: %v", *history, err)
+	}
+	go keepHistory()
+
 	http.HandleFunc("/coverage", collectCoverage)
 	http.HandleFunc("/quit", handleQuit)
+	http.HandleFunc("/sarif", handleSarif)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/firsts", handleFirsts)
+	http.HandleFunc("/checkpoints", handleCheckpoints)
+	http.HandleFunc("/inject/", handleInject)
+	http.HandleFunc("/mutants", handleMutants)
+	http.HandleFunc("/mutants/", handleMutants)
+	http.HandleFunc("/sessions", handleSessions)
+	http.HandleFunc("/tests", handleTests)
+	http.HandleFunc("/tests/blocks", handleTestBlocks)
+	http.HandleFunc("/minimize", handleMinimize)
+	http.HandleFunc("/outcome", handleOutcome)
+	http.HandleFunc("/suspicious", handleSuspicious)
+	http.HandleFunc("/gaps", handleGaps)
+	http.HandleFunc("/gaps/", handleGaps)
+	http.HandleFunc("/dead", handleDead)
+	http.HandleFunc("/functions", handleFunctions)
+	http.HandleFunc("/export", handleExport)
+	http.HandleFunc("/badge.svg", handleBadge)
+	http.HandleFunc("/summary.txt", handleSummary)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc(apiPrefix, handleAPI)
+	http.HandleFunc("/sessions/", handleSessions)
+	http.HandleFunc("/reset", handleReset)
+	http.HandleFunc("/snapshots", handleSnapshots)
+	http.HandleFunc("/snapshots/", handleSnapshots)
+	http.HandleFunc("/diff", handleDiff)
+	http.HandleFunc("/diff/", handleDiff)
 	http.HandleFunc("/", handleReporting)
 
 	http.ListenAndServe(*connection, nil)
 }
 
 func collectCoverage(w http.ResponseWriter, r *http.Request) {
-	reader := bufio.NewReader(r.Body)
+	atomic.AddInt64(&connectedSenders, 1)
+	defer atomic.AddInt64(&connectedSenders, -1)
+	defer recoverParseError()
+
+	reader := bufio.NewReader(countingReader{r.Body})
+
+	// The blocks of a file are reported right after its source. Once all of
+	// them are in, the history of blocks the source no longer has is dropped.
+	reporting := ""
+	var reported map[blockKey]bool
+	finishReport := func() {
+		if reported != nil {
+			countsLock.Lock()
+			forgetUnreported(reporting, reported)
+			countsLock.Unlock()
+		}
+		reported = nil
+	}
 
 	for {
 		first, err := reader.ReadByte()
 
 		if err != nil {
+			if err == io.EOF {
+				finishReport()
+			}
 			break
 		}
 
@@ -49,95 +121,259 @@ func collectCoverage(w http.ResponseWriter, r *http.Request) {
 		case 'F':
 			filename := readNetstring(reader)
 			source := readNetstring(reader)
+			finishReport()
 
 			countsLock.Lock()
 			sources[filename] = source
 			countsLock.Unlock()
+			reporting, reported = filename, make(map[blockKey]bool)
 
 		case 'C':
-			collectBlock(reader, 1)
+			collectBlock(reader, 1, "", "")
+
+		case 'S':
+			scope := readNetstring(reader)
+			collectBlock(reader, 1, scope, "")
+
+		case 'T':
+			test := readNetstring(reader)
+			scope := readNetstring(reader)
+			collectBlock(reader, 1, scope, test)
 
 		case 'B':
-			collectBlock(reader, 0)
+			key := collectBlock(reader, 0, "", "")
+			if key.file != reporting {
+				finishReport()
+			} else if reported != nil {
+				reported[key] = true
+			}
+
+		case 'E':
+			filename := readNetstring(reader)
+			line := readInt(reader)
+			col := readInt(reader)
+			call := readNetstring(reader)
+
+			collectInjectionSite(filename, line, col, call)
+
+		case 'U':
+			filename := readNetstring(reader)
+			id := readInt(reader)
+			line := readInt(reader)
+			col := readInt(reader)
+			description := readNetstring(reader)
+
+			collectMutant(filename, id, line, col, description)
+
+		case 'K':
+			collectCheckpoint(readNetstring(reader))
+
+		case 'M':
+			name := readNetstring(reader)
+
+			countsLock.Lock()
+			switchSession(name)
+			countsLock.Unlock()
+
+		case 'O':
+			outcome := readNetstring(reader)
+			if !validOutcome(outcome) {
+				panic(parseError(fmt.Sprintf("invalid outcome %q", outcome)))
+			}
+
+			countsLock.Lock()
+			current.outcome = outcome
+			countsLock.Unlock()
 
 		default:
-			log.Fatalf("Invalid coverage report header: %d", first)
+			panic(parseError(fmt.Sprintf("invalid coverage report header: %d", first)))
 		}
+
+		atomic.AddInt64(&recordsIngested, 1)
 	}
 }
 
-func collectBlock(reader *bufio.Reader, delta int) {
+// collectBlock reads the position of a block, counting delta executions of it,
+// and returns which block it was.
+func collectBlock(reader *bufio.Reader, delta int, scope, test string) blockKey {
 	filename := readNetstring(reader)
 	startLine := readInt(reader)
 	startCol := readInt(reader)
 	endLine := readInt(reader)
 	endCol := readInt(reader)
 	numStmt := readInt(reader)
+	key := blockKey{file: filename, line: startLine, col: startCol}
 
 	countsLock.Lock()
-	if counts == nil {
-		counts = make(map[string]map[int]map[int]*block)
-	}
+	if delta == 0 {
+		// Every session knows about every block, so that totals stay comparable.
+		for _, s := range sessions {
+			s.addBlock(filename, startLine, startCol, endLine, endCol, numStmt)
+		}
+		recordReported(key, time.Now())
+	} else {
+		b := current.addBlock(filename, startLine, startCol, endLine, endCol, numStmt)
+		b.count += delta
+		if scope != "" && (current.scopes[scope] != nil || len(current.scopes) < *maxScopes) {
+			current.scopes.add(scope, filename, startLine, startCol)
+		}
+		if test != "" {
+			current.tests.add(test, filename, startLine, startCol)
+		}
 
-	if counts[filename] == nil {
-		counts[filename] = make(map[int]map[int]*block)
-	}
+		recordExecuted(key, time.Now())
+		first := !everCovered[key]
+		if first {
+			everCovered[key] = true
+			firstCoverages++
+		}
 
-	if counts[filename][startLine] == nil {
-		counts[filename][startLine] = make(map[int]*block)
+		publishHit(hit{
+			Session: current.name,
+			File:    filename,
+			Line:    startLine,
+			Col:     startCol,
+			Count:   b.count,
+			First:   first,
+			Scope:   scope,
+		})
 	}
+	countsLock.Unlock()
 
-	if counts[filename][startLine][startCol] == nil {
-		counts[filename][startLine][startCol] = &block{
-			startLine: startLine,
-			startCol: startCol,
-			endLine: endLine,
-			endCol: endCol,
-			numStmt: numStmt,
-			count: 0,
-		}
-	}
+	return key
+}
 
-	counts[filename][startLine][startCol].count += delta
+// parseError is raised by the readers of a report which cannot be parsed.
+type parseError string
 
-	countsLock.Unlock()
+func (e parseError) Error() string {
+	return string(e)
 }
 
 func readInt(r *bufio.Reader) int {
 	str, err := r.ReadString(':')
 	if err != nil {
-		log.Fatalf("could not parse cover report: %v", err)
+		panic(parseError(fmt.Sprintf("could not parse cover report: %v", err)))
 	}
 
 	var result int
 	count, err := fmt.Sscanf(str, "%d", &result)
 	if err != nil {
-		log.Fatalf("could not parse cover report: %v", err)
+		panic(parseError(fmt.Sprintf("could not parse cover report: %v", err)))
 	}
 
 	if count != 1 {
-		log.Fatalf("could not parse cover report, no count")
+		panic(parseError("could not parse cover report, no count"))
 	}
 
 	return result
 }
 
+// maxNetstring bounds the length of strings in a report, far above any source
+// file, so that a corrupt length cannot exhaust the daemon's memory.
+const maxNetstring = 64 << 20
+
 func readNetstring(r *bufio.Reader) string {
 	length := readInt(r)
+	if length < 0 || length > maxNetstring {
+		panic(parseError(fmt.Sprintf("could not parse cover report, invalid length %d", length)))
+	}
 	resultBuf := make([]byte, length)
 	count, err := io.ReadFull(r, resultBuf)
 	if err != nil {
-		log.Fatalf("could not parse cover report: %v", err)
+		panic(parseError(fmt.Sprintf("could not parse cover report: %v", err)))
 	}
 
 	if count != len(resultBuf) {
-		log.Fatalf("could not parse cover report, ReadFull returned wrong count")
+		panic(parseError("could not parse cover report, ReadFull returned wrong count"))
 	}
 
 	return string(resultBuf)
 }
 
+// intParam parses an optional integer query parameter.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %v", name, err)
+	}
+	return result, nil
+}
+
+// sortedFilenames returns the names of all reported sources in lexical order.
+// The caller must hold countsLock.
+func sortedFilenames() []string {
+	var result []string
+	for filename := range sources {
+		result = append(result, filename)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// fileURL returns the URL of the page about filename below prefix, escaping
+// each segment of the file name.
+func fileURL(prefix, filename string) string {
+	segments := strings.Split(filename, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return prefix + strings.Join(segments, "/")
+}
+
+// sortedBlocks returns the blocks of one file ordered by position.
+func sortedBlocks(fileCounts map[int]map[int]*block) []*block {
+	var result []*block
+	for _, lineCounts := range fileCounts {
+		for _, b := range lineCounts {
+			result = append(result, b)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].startLine != result[j].startLine {
+			return result[i].startLine < result[j].startLine
+		}
+		return result[i].startCol < result[j].startCol
+	})
+	return result
+}
+
+// statementCoverage sums up covered and total statements of the given blocks.
+func statementCoverage(fileCounts map[int]map[int]*block) (covered, total int) {
+	for _, lineCounts := range fileCounts {
+		for _, b := range lineCounts {
+			total += b.numStmt
+
+			if b.count > 0 {
+				covered += b.numStmt
+			}
+		}
+	}
+	return covered, total
+}
+
+// handleFirsts reports how many blocks were executed for the first time since the daemon started.
+func handleFirsts(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	fmt.Fprintf(w, "%d\n", firstCoverages)
+}
+
+// sourcePage returns the URL of the source view of filename in session s.
+func sourcePage(filename string, s *session) string {
+	return fileURL("/", filename) + "?session=" + url.QueryEscape(s.name)
+}
+
 func handleQuit(w http.ResponseWriter, r *http.Request) {
+	if err := saveHistory(); err != nil {
+		log.Printf("saving history: %v", err)
+	}
 	go os.Exit(0)
 }
 
@@ -147,7 +383,10 @@ func handleReporting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	countsLock.Lock()
 	_, ok := sources[r.URL.Path[1:]]
+	countsLock.Unlock()
+
 	if ok {
 		handleSource(w, r, r.URL.Path[1:])
 		return
@@ -157,66 +396,101 @@ func handleReporting(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	test := r.URL.Query().Get("test")
+	counts := s.viewCounts(scope, test)
+
 	fmt.Fprintf(w, `
 <html><head>
 </head><body>
-  <ul>
-`)
-	for filename := range sources {
-		totalStmt := 0
-		coveredStmt := 0
-
-		for _, lineCounts := range counts[filename] {
-			for _, block := range lineCounts {
-				totalStmt += block.numStmt
+  <p>Session: %s (<a href="/sessions">all sessions</a>, <a href="/functions?%s">functions</a>)</p>
+`, html.EscapeString(s.name), viewQuery(s, scope, test))
+	writeScopeLinks(w, s, "/", scope)
+	writeTestLink(w, s, test)
 
-				if block.count > 0 {
-					coveredStmt += block.numStmt
-				}
-			}
-		}
+	filter := requestedFilter(r)
+	writeFilterForm(w, r, filter)
 
-		if totalStmt > 0 {
-			fmt.Fprintf(w, `
-	<li><a href="%s">%s</a> (%3.2f%% %d/%d)</li>
-`, filename, filename, float32(coveredStmt) / float32(totalStmt) * 100, coveredStmt, totalStmt)
-		} else {
-			fmt.Fprintf(w, `
-	<li><a href="%s">%s</a> (no statements)</li>
-`, filename, filename)
-		}
-	}
+	root := buildTree(counts, filter)
+	fmt.Fprintf(w, "  <p>Total: %s</p>\n", formatCoverage(root.coveredStmt, root.totalStmt))
+	query := viewQuery(s, scope, test)
+	writeTree(w, root, filter, func(filename string) string {
+		return fileURL("/", filename) + "?" + query
+	})
 
 	fmt.Fprintf(w, `
-  </ul>
 </body></html>
 `)
 }
 
 func handleSource(w http.ResponseWriter, r *http.Request, filename string) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("formula") != "" {
+		writeSuspiciousSource(w, r, s, filename)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	test := r.URL.Query().Get("test")
+	owners := s.tests.owners()
+	counts := s.viewCounts(scope, test)[filename]
+	max := maxCount(counts)
+
 	fmt.Fprintf(w, `
 <html><head>
 </head><body style="background-color: black; color: white;">
-<pre>%s`, changeColor(0))
+`)
+	writeScopeLinks(w, s, fileURL("/", filename), scope)
+	writeTestLink(w, s, test)
+	renderSource(w, sources[filename], counts, sourceColor(s, filename, max, owners), survivingMutants(filename))
+	writeInjectionSites(w, filename, sourcePage(filename, s))
+	fmt.Fprintf(w, `%s
+</body></html>
+`, animationScript(filename, s.name, scope))
+}
 
-	lines := strings.Split(sources[filename], "\n")
-	n := make([][]int, len(lines))
+// renderSource writes source as preformatted HTML, styling every character by
+// the block containing it (nil outside of all blocks). The style function returns
+// the markup closing the previous span and opening a new one, see changeColor.
+// Markup in marks, key is [line][col], is inserted before the given character.
+// Every line is numbered, linkable as #L<line> or #L<from>-L<to>, and shows the
+// highest execution count of the blocks on it.
+//
+// Blocks are positioned by byte, like go/token's columns, but the source is
+// written rune by rune (styled by the block of its first byte) and escaped.
+// Within the block styles, which set the background, Go tokens are highlighted.
+func renderSource(w io.Writer, source string, fileCounts map[int]map[int]*block, style func(*block) string, marks map[int]map[int]string) {
+	lines := strings.Split(source, "\n")
+	n := make([][]*block, len(lines))
 
 	for i, line := range lines {
-		n[i] = make([]int, len(line))
-
-		for j := 0; j < len(line); j++ {
-			n[i][j] = -1
-		}
+		n[i] = make([]*block, len(line))
 	}
 
-	for _, lineData := range counts[filename] {
+	for _, lineData := range fileCounts {
 		for _, data := range lineData {
 			y := data.startLine - 1
 			x := data.startCol - 1
 
 			for {
-				n[y][x] = data.count
+				n[y][x] = data
 				x++
 
 				for x >= len(n[y]) {
@@ -235,36 +509,236 @@ func handleSource(w http.ResponseWriter, r *http.Request, filename string) {
 		}
 	}
 
-	lastCount := 0
-	for y, lineCount := range n {
-		for x, c := range lineCount {
-			if c != lastCount {
-				fmt.Fprintf(w, "%s", changeColor(c))
-				lastCount = c
+	classes := tokenClasses(source)
+	offset := 0
+
+	lastStyle := style(nil)
+	fmt.Fprintf(w, "%s%s<pre>", sourceStyle, syntaxStyle)
+	for y, lineBlocks := range n {
+		hits := ""
+		for _, b := range lineBlocks {
+			if b != nil {
+				hits = strconv.Itoa(lineMax(lineBlocks))
+				break
+			}
+		}
+
+		// Each line gets spans of its own, so the styles of blocks are reopened
+		// after the line number. The empty span is closed by the style.
+		fmt.Fprintf(w, `<span class="line" id="L%d"><a class="ln" href="#L%d">%5d</a><span class="hits">%7s</span> <span>%s`,
+			y+1, y+1, y+1, hits, lastStyle)
+		line := lines[y]
+		lastClass := ""
+		for x := 0; x < len(line); {
+			r, size := utf8.DecodeRuneInString(line[x:])
+
+			next := style(lineBlocks[x])
+			class := classes[offset+x]
+			if next != lastStyle || class != lastClass {
+				if lastClass != "" {
+					fmt.Fprintf(w, "</span>")
+				}
+				if next != lastStyle {
+					fmt.Fprintf(w, "%s", next)
+					lastStyle = next
+				}
+				if class != "" {
+					fmt.Fprintf(w, `<span class="%s">`, class)
+				}
+				lastClass = class
+			}
+
+			for i := 0; i < size; i++ {
+				fmt.Fprintf(w, "%s", marks[y+1][x+i+1])
+			}
+
+			io.WriteString(w, html.EscapeString(string(r)))
+			x += size
+		}
+		if lastClass != "" {
+			fmt.Fprintf(w, "</span>")
+		}
+		fmt.Fprintf(w, "</span></span>\n")
+		offset += len(line) + 1
+	}
+
+	fmt.Fprintf(w, `</pre>
+%s`, navigationScript)
+}
+
+// lineMax returns the highest execution count of the blocks on a line.
+func lineMax(lineBlocks []*block) int {
+	max := 0
+	for _, b := range lineBlocks {
+		if b != nil && b.count > max {
+			max = b.count
+		}
+	}
+	return max
+}
+
+// maxCount returns the highest execution count of the blocks of a file.
+func maxCount(fileCounts map[int]map[int]*block) int {
+	max := 0
+	for _, lineCounts := range fileCounts {
+		for _, b := range lineCounts {
+			if b.count > max {
+				max = b.count
 			}
+		}
+	}
+	return max
+}
 
-			fmt.Fprintf(w, "%c", lines[y][x])
+const sourceStyle = `
+<style>
+  .line { display: block; }
+  .line.selected { background-color: #303030; }
+  .ln { color: #606060; text-decoration: none; }
+  .hits { color: #808000; }
+  .current { outline: 1px solid #ffff00; }
+</style>
+`
+
+// navigationScript highlights the lines named in the location's hash, extends
+// the range on shift-click of a line number, and jumps to the next ("n") or
+// previous ("p") uncovered block.
+const navigationScript = `
+<script>
+(function() {
+  function select() {
+    var m = location.hash.match(/^#L(\d+)(?:-L(\d+))?$/);
+    var selected = document.querySelectorAll(".line.selected");
+    for (var i = 0; i < selected.length; i++) {
+      selected[i].classList.remove("selected");
+    }
+    if (!m) {
+      return;
+    }
+
+    var from = +m[1], to = +(m[2] || m[1]);
+    for (var l = Math.min(from, to); l <= Math.max(from, to); l++) {
+      var line = document.getElementById("L" + l);
+      if (line) {
+        line.classList.add("selected");
+      }
+    }
+    var first = document.getElementById("L" + Math.min(from, to));
+    if (first) {
+      first.scrollIntoView({block: "center"});
+    }
+  }
+  window.addEventListener("hashchange", select);
+  select();
+
+  var anchor = null;
+  document.addEventListener("click", function(e) {
+    if (!e.target.classList || !e.target.classList.contains("ln")) {
+      return;
+    }
+    var line = e.target.parentNode.id;
+    if (e.shiftKey && anchor) {
+      e.preventDefault();
+      location.hash = "#" + anchor + "-" + line;
+    } else {
+      anchor = line;
+    }
+  });
+
+  // The first span of every uncovered block, in source order.
+  var uncovered = [];
+  var seen = {};
+  var spans = document.querySelectorAll(".uncovered");
+  for (var i = 0; i < spans.length; i++) {
+    var id = spans[i].getAttribute("data-block");
+    if (!seen[id]) {
+      seen[id] = true;
+      uncovered.push(spans[i]);
+    }
+  }
+
+  var position = -1;
+  document.addEventListener("keydown", function(e) {
+    if (e.target.tagName == "INPUT" || e.ctrlKey || e.altKey || e.metaKey || uncovered.length == 0) {
+      return;
+    }
+    if (e.key == "n") {
+      position = (position + 1) % uncovered.length;
+    } else if (e.key == "p") {
+      position = (position + uncovered.length - 1) % uncovered.length;
+    } else {
+      return;
+    }
+
+    var current = document.querySelector(".current");
+    if (current) {
+      current.classList.remove("current");
+    }
+    uncovered[position].classList.add("current");
+    uncovered[position].scrollIntoView({block: "center"});
+  });
+})();
+</script>
+`
+
+// sourceColor styles the blocks of filename in session s: blocks executed only
+// through injected errors stand out, then blocks are attributed to the tests
+// in owners, if any, or else colored by their count relative to max.
+func sourceColor(s *session, filename string, max int, owners map[blockKey][]string) func(*block) string {
+	return func(b *block) string {
+		if b != nil && s.onlyInjected(filename, b) {
+			return injectedColor(b)
+		}
+		if b != nil && len(owners) > 0 {
+			return testsColor(b, max, owners[blockKey{file: filename, line: b.startLine, col: b.startCol}])
 		}
-		fmt.Fprintf(w, "\n")
+		return countColor(b, max)
 	}
+}
 
-	fmt.Fprintf(w, `
-</span></pre>
-</body></html>
-`)
+// countColor styles a block by its execution count relative to the highest
+// count max, tagging it for the live animation and the navigation.
+func countColor(b *block, max int) string {
+	if b == nil {
+		return changeColor(-1)
+	}
+
+	style := changeColor(b.count)
+	if b.count > 0 {
+		style = heatColor(b.count, max)
+	}
+
+	class := ""
+	if b.count == 0 {
+		class = ` class="uncovered"`
+	}
+	return strings.Replace(style, "<span", fmt.Sprintf(`<span data-block="%d:%d"%s`, b.startLine, b.startCol, class), 1)
+}
+
+// heatColor styles an executed block from green (executed once) to yellow
+// (executed max times), on a logarithmic scale.
+func heatColor(c, max int) string {
+	heat := 0.0
+	if max > 1 {
+		heat = math.Log(float64(c)) / math.Log(float64(max))
+	}
+
+	red := int(heat * 0x60)
+	green := 0x38 + int(heat*0x28)
+	return fmt.Sprintf(`</span><span style="background-color: #%02x%02x00" title="%d">`, red, green, c)
 }
 
 func changeColor(c int) string {
 	switch {
 	case c == -1:
-		return `</span><span style="color: #808080">`
+		return `</span><span>`
 	case c == 0:
-		return `</span><span style="color: #ff0000" title="0">`
+		return `</span><span style="background-color: #600000" title="0">`
 	default:
-		green := 255
+		green := 0x50
 		blue := 0
 
-		return fmt.Sprintf(`</span><span style="color: #00%02x%02x" title="%d">`,
+		return fmt.Sprintf(`</span><span style="background-color: #00%02x%02x" title="%d">`,
 			green, blue, c)
 	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// positionRange is an inclusive range of source positions.
+type positionRange struct {
+	startLine, startCol int
+	endLine, endCol     int
+}
+
+// errorHandling returns the bodies of all "if err != nil" statements of file.
+func errorHandling(file *ast.File, fset *token.FileSet) []positionRange {
+	var result []positionRange
+	ast.Inspect(file, func(node ast.Node) bool {
+		n, ok := node.(*ast.IfStmt)
+		if !ok || !checksAnyError(n.Cond) {
+			return true
+		}
+
+		start := fset.Position(n.Body.Lbrace)
+		end := fset.Position(n.Body.Rbrace)
+		result = append(result, positionRange{start.Line, start.Column, end.Line, end.Column})
+		return true
+	})
+	return result
+}
+
+// checksAnyError reports whether cond compares an error variable to nil.
+func checksAnyError(cond ast.Expr) bool {
+	found := false
+	ast.Inspect(cond, func(node ast.Node) bool {
+		if binary, ok := node.(*ast.BinaryExpr); ok {
+			if x, ok := binary.X.(*ast.Ident); ok && isErrorName(x.Name) && checksError(binary, x.Name) {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// within reports whether the position is inside any of ranges.
+func within(ranges []positionRange, line, col int) bool {
+	for _, r := range ranges {
+		if before(r.startLine, r.startCol, line, col) && before(line, col, r.endLine, r.endCol) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownBlocks returns the blocks of filename known to any session, as a block
+// executed without having been reported is only known to the session it was
+// executed in. The caller must hold countsLock.
+func knownBlocks(filename string) map[int]map[int]*block {
+	known := make(map[int]map[int]*block)
+	for _, s := range sessions {
+		for line, lineCounts := range s.counts[filename] {
+			if known[line] == nil {
+				known[line] = make(map[int]*block)
+			}
+			for col, b := range lineCounts {
+				if known[line][col] == nil {
+					known[line][col] = b
+				}
+			}
+		}
+	}
+	return known
+}
+
+// handleDead lists per file and function the blocks not executed since the
+// date given as "since" (YYYY-MM-DD), except for those handling errors, with
+// the date each block was first reported.
+func handleDead(w http.ResponseWriter, r *http.Request) {
+	since, err := time.Parse("2006-01-02", r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, "since must be a date like 2006-01-02", http.StatusBadRequest)
+		return
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>Blocks not executed since %s, excluding error handling</p>
+  <table border="1">
+	<tr><th>file</th><th>function</th><th>blocks (first reported)</th></tr>
+`, since.Format("2006-01-02"))
+	for _, filename := range sortedFilenames() {
+		file, fset, err := parseSource(filename, sources[filename])
+		if err != nil {
+			fmt.Fprintf(w, "\t<tr><td>%s</td><td colspan=\"2\">%s</td></tr>\n", html.EscapeString(filename), html.EscapeString(err.Error()))
+			continue
+		}
+
+		fns := functions(file, fset)
+		errorRanges := errorHandling(file, fset)
+
+		var order []string
+		candidates := make(map[string][]string)
+		alive := make(map[string]bool)
+		for _, b := range sortedBlocks(knownBlocks(filename)) {
+			if within(errorRanges, b.startLine, b.startCol) {
+				continue
+			}
+
+			name := "(package level)"
+			if fn := functionOf(fns, b.startLine, b.startCol); fn != nil {
+				name = fn.name
+			}
+
+			h := histories[blockKey{file: filename, line: b.startLine, col: b.startCol}]
+			if h == nil {
+				continue
+			}
+			if !h.lastExecuted.Before(since) {
+				alive[name] = true
+				continue
+			}
+
+			if candidates[name] == nil {
+				order = append(order, name)
+			}
+			candidates[name] = append(candidates[name], fmt.Sprintf("%d.%d-%d.%d (%s)",
+				b.startLine, b.startCol, b.endLine, b.endCol, h.firstReported.Format("2006-01-02")))
+		}
+
+		for _, name := range order {
+			label := name
+			if !alive[name] {
+				label += " (entirely)"
+			}
+
+			fmt.Fprintf(w, "\t<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+				html.EscapeString(fileURL("/", filename)), html.EscapeString(filename), label, strings.Join(candidates[name], "<br>"))
+		}
+	}
+	fmt.Fprintf(w, `
+  </table>
+</body></html>
+`)
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshot is the on-disk representation of a session.
+type snapshot struct {
+	Session string                    `json:"session"`
+	Taken   time.Time                 `json:"taken"`
+	Files   map[string]snapshotSource `json:"files"`
+}
+
+type snapshotSource struct {
+	Source string          `json:"source"`
+	Blocks []snapshotBlock `json:"blocks"`
+}
+
+type snapshotBlock struct {
+	StartLine int `json:"startLine"`
+	StartCol  int `json:"startCol"`
+	EndLine   int `json:"endLine"`
+	EndCol    int `json:"endCol"`
+	NumStmt   int `json:"numStmt"`
+	Count     int `json:"count"`
+}
+
+// snapshotSuffix tells snapshots apart from other files in -snapshotDir.
+const snapshotSuffix = ".snapshot.json"
+
+// snapshotPath returns where the named snapshot is stored.
+func snapshotPath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+
+	return filepath.Join(*snapshotDir, name+snapshotSuffix), nil
+}
+
+// handleSnapshots lists the stored snapshots on GET /snapshots and stores
+// the current (or the requested) session on POST /snapshots/<name>.
+func handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/snapshots"), "/")
+
+	if name == "" {
+		matches, err := filepath.Glob(filepath.Join(*snapshotDir, "*"+snapshotSuffix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, match := range matches {
+			fmt.Fprintln(w, strings.TrimSuffix(filepath.Base(match), snapshotSuffix))
+		}
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "use POST to store a snapshot", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path, err := snapshotPath(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	countsLock.Lock()
+	s := requestedSession(r)
+	if s == nil {
+		countsLock.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+
+	snap := snapshot{
+		Session: s.name,
+		Taken:   time.Now(),
+		Files:   make(map[string]snapshotSource),
+	}
+	for filename, source := range sources {
+		var blocks []snapshotBlock
+		for _, b := range sortedBlocks(s.counts[filename]) {
+			blocks = append(blocks, snapshotBlock{
+				StartLine: b.startLine,
+				StartCol:  b.startCol,
+				EndLine:   b.endLine,
+				EndCol:    b.endCol,
+				NumStmt:   b.numStmt,
+				Count:     b.count,
+			})
+		}
+
+		snap.Files[filename] = snapshotSource{Source: source, Blocks: blocks}
+	}
+	countsLock.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "session %s stored as %s\n", s.name, path)
+}
+
+// loadCoverage resolves name to a session or, if there is no session of that
+// name, to a stored snapshot. It returns the counts together with the sources
+// they refer to, which the caller may only read while holding countsLock.
+// The caller must not hold countsLock, so reading a snapshot does not block
+// the collection.
+func loadCoverage(name string) (*session, map[string]string, error) {
+	countsLock.Lock()
+	s, ok := sessions[name]
+	countsLock.Unlock()
+	if ok {
+		return s, sources, nil
+	}
+
+	path, err := snapshotPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("no session or snapshot named %q", name)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("snapshot %s: %v", path, err)
+	}
+
+	s = &session{
+		name:     name,
+		started:  snap.Taken,
+		counts:   make(map[string]map[int]map[int]*block),
+		injected: make(map[string]map[int]map[int]int),
+		scopes:   make(tally),
+		tests:    make(tally),
+	}
+	snapSources := make(map[string]string)
+	for filename, file := range snap.Files {
+		snapSources[filename] = file.Source
+
+		for _, b := range file.Blocks {
+			s.addBlock(filename, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt).count = b.Count
+		}
+	}
+
+	return s, snapSources, nil
+}
+
+// blockChange classifies how a block's coverage differs between two tallies.
+type blockChange int
+
+const (
+	unchanged blockChange = iota
+	gained
+	lost
+	countChanged
+)
+
+// compareBlocks classifies the change from before to after, either of which may be nil.
+func compareBlocks(before, after *block) blockChange {
+	beforeCount, afterCount := before.countOrZero(), after.countOrZero()
+
+	switch {
+	case beforeCount == 0 && afterCount > 0:
+		return gained
+	case beforeCount > 0 && afterCount == 0:
+		return lost
+	case beforeCount != afterCount:
+		return countChanged
+	}
+	return unchanged
+}
+
+// countOrZero returns the execution count of a possibly missing block.
+func (b *block) countOrZero() int {
+	if b == nil {
+		return 0
+	}
+	return b.count
+}
+
+// lookupBlock finds the block starting at the given position, or nil.
+func lookupBlock(fileCounts map[int]map[int]*block, startLine, startCol int) *block {
+	if fileCounts[startLine] == nil {
+		return nil
+	}
+	return fileCounts[startLine][startCol]
+}
+
+// loadComparison resolves the "from" and "to" query parameters.
+// The caller must not hold countsLock.
+func loadComparison(r *http.Request) (from, to *session, fromSources, toSources map[string]string, err error) {
+	from, fromSources, err = loadCoverage(r.URL.Query().Get("from"))
+	if err != nil {
+		return
+	}
+
+	to, toSources, err = loadCoverage(r.URL.Query().Get("to"))
+	return
+}
+
+// comparisonPage describes the pages comparing two coverages: a table listing
+// blocks of each file by how they differ, and the overlay of one file.
+type comparisonPage struct {
+	path    string // of the table, followed by the file name for an overlay
+	query   string // selecting what is compared, kept by links to overlays
+	title   string // HTML heading of the table
+	legend  string // HTML explaining the colors of an overlay
+	columns []string
+
+	filenames []string                          // files listed in the table
+	sources   map[string]string                 // files which have an overlay
+	counts    map[string]map[int]map[int]*block // blocks drawn in an overlay
+
+	// entries returns the HTML listed for filename in each of columns.
+	entries func(filename string) [][]string
+	// color styles a block drawn in the overlay of filename.
+	color func(filename string, b *block) string
+	// omitEmpty leaves out files without any entries.
+	omitEmpty bool
+}
+
+// serve shows the overlay of the file named by the rest of the URL path or, if
+// none is named, the table.
+func (p *comparisonPage) serve(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, p.path), "/")
+	if filename != "" {
+		source, ok := p.sources[filename]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, `
+<html><head>
+</head><body style="background-color: black; color: white;">
+<p>%s: %s</p>
+`, html.EscapeString(filename), p.legend)
+		renderSource(w, source, p.counts[filename], func(b *block) string {
+			if b == nil {
+				return changeColor(-1)
+			}
+			return p.color(filename, b)
+		}, nil)
+		fmt.Fprintf(w, `
+</body></html>
+`)
+		return
+	}
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>%s</p>
+  <table border="1">
+	<tr><th>file</th><th>%s</th></tr>
+`, p.title, strings.Join(p.columns, "</th><th>"))
+	for _, filename := range p.filenames {
+		entries := p.entries(filename)
+
+		var cells []string
+		empty := true
+		for _, column := range entries {
+			cells = append(cells, strings.Join(column, "<br>"))
+			empty = empty && len(column) == 0
+		}
+		if empty && p.omitEmpty {
+			continue
+		}
+
+		name := html.EscapeString(filename)
+		if _, ok := p.sources[filename]; ok {
+			name = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(fileURL(p.path+"/", filename)+"?"+p.query), name)
+		}
+
+		fmt.Fprintf(w, "\t<tr><td>%s</td><td>%s</td></tr>\n", name, strings.Join(cells, "</td><td>"))
+	}
+	fmt.Fprintf(w, `
+  </table>
+</body></html>
+`)
+}
+
+// unionFilenames returns the files of both a and b, sorted.
+func unionFilenames(a, b map[string]string) []string {
+	filenames := make(map[string]bool)
+	for filename := range a {
+		filenames[filename] = true
+	}
+	for filename := range b {
+		filenames[filename] = true
+	}
+
+	var sorted []string
+	for filename := range filenames {
+		sorted = append(sorted, filename)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// handleDiff lists per file which blocks were newly covered, newly uncovered
+// or executed a different number of times between two sessions or snapshots
+// on GET /diff?from=<name>&to=<name>, and shows the overlay of one file on
+// GET /diff/<file>?from=<name>&to=<name>.
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	from, to, fromSources, toSources, err := loadComparison(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	page := comparisonPage{
+		path:  "/diff",
+		query: fmt.Sprintf("from=%s&to=%s", url.QueryEscape(from.name), url.QueryEscape(to.name)),
+		title: fmt.Sprintf("Changes from %s to %s", html.EscapeString(from.name), html.EscapeString(to.name)),
+		legend: fmt.Sprintf(`<span style="background-color: #005000">gained</span>, <span style="background-color: #600000">lost</span>, unchanged from %s to %s`,
+			html.EscapeString(from.name), html.EscapeString(to.name)),
+		columns: []string{"newly covered", "newly uncovered", "count changed"},
+
+		filenames: unionFilenames(fromSources, toSources),
+		sources:   toSources,
+		counts:    to.counts,
+
+		entries: func(filename string) [][]string {
+			changes := make(map[blockChange][]string)
+
+			for _, b := range sortedBlocks(from.counts[filename]) {
+				if lookupBlock(to.counts[filename], b.startLine, b.startCol) == nil {
+					if change := compareBlocks(b, nil); change != unchanged {
+						changes[change] = append(changes[change], describeBlock(b, nil))
+					}
+				}
+			}
+			for _, b := range sortedBlocks(to.counts[filename]) {
+				before := lookupBlock(from.counts[filename], b.startLine, b.startCol)
+				if change := compareBlocks(before, b); change != unchanged {
+					changes[change] = append(changes[change], describeBlock(before, b))
+				}
+			}
+
+			return [][]string{changes[gained], changes[lost], changes[countChanged]}
+		},
+		color: func(filename string, b *block) string {
+			return diffColor(lookupBlock(from.counts[filename], b.startLine, b.startCol), b)
+		},
+	}
+	page.serve(w, r)
+}
+
+// describeBlock names a block by its position and execution counts before and after.
+func describeBlock(before, after *block) string {
+	b := after
+	if b == nil {
+		b = before
+	}
+
+	return fmt.Sprintf("%d.%d-%d.%d (%d &rarr; %d)", b.startLine, b.startCol, b.endLine, b.endCol,
+		before.countOrZero(), after.countOrZero())
+}
+
+// diffColor styles a block by how its coverage changed.
+func diffColor(before, after *block) string {
+	title := fmt.Sprintf("%d -> %d", before.countOrZero(), after.countOrZero())
+
+	switch compareBlocks(before, after) {
+	case gained:
+		return fmt.Sprintf(`</span><span style="background-color: #005000" title="%s">`, title)
+	case lost:
+		return fmt.Sprintf(`</span><span style="background-color: #600000" title="%s">`, title)
+	}
+	return fmt.Sprintf(`</span><span title="%s">`, title)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// hit is one block execution as pushed to the browser.
+type hit struct {
+	Session string `json:"session"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Count   int    `json:"count"`
+	First   bool   `json:"first,omitempty"` // executed for the first time since the daemon started
+	Scope   string `json:"scope,omitempty"`
+}
+
+// subscribers receive every hit; slow ones miss some.
+var subscribers = make(map[chan hit]bool)
+var subscribersLock sync.Mutex
+
+// publishHit passes a block execution on to all subscribers without blocking.
+func publishHit(h hit) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- h:
+		default:
+		}
+	}
+}
+
+// handleEvents streams hits as Server-Sent Events. The optional "file", "session"
+// and "scope" query parameters restrict the stream to hits of that file, session and scope.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	file := r.URL.Query().Get("file")
+	sessionName := r.URL.Query().Get("session")
+	scope := r.URL.Query().Get("scope")
+
+	ch := make(chan hit, 1024)
+	subscribersLock.Lock()
+	subscribers[ch] = true
+	subscribersLock.Unlock()
+
+	defer func() {
+		subscribersLock.Lock()
+		delete(subscribers, ch)
+		subscribersLock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case h := <-ch:
+			if (file != "" && h.File != file) || (sessionName != "" && h.Session != sessionName) || (scope != "" && h.Scope != scope) {
+				continue
+			}
+
+			data, err := json.Marshal(h)
+			if err != nil {
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// animationScript subscribes the source view of filename in the given session
+// and scope to live hits, flashing executed blocks and fading them out again.
+func animationScript(filename, sessionName, scope string) string {
+	file, _ := json.Marshal(filename)
+	name, _ := json.Marshal(sessionName)
+	scopeName, _ := json.Marshal(scope)
+
+	return fmt.Sprintf(`
+<script>
+(function() {
+  var fade = %d;
+  var events = new EventSource("/events?file=" + encodeURIComponent(%s) + "&session=" + encodeURIComponent(%s) + "&scope=" + encodeURIComponent(%s));
+  events.onmessage = function(e) {
+    var hit = JSON.parse(e.data);
+    var spans = document.querySelectorAll('[data-block="' + hit.line + ':' + hit.col + '"]');
+    for (var i = 0; i < spans.length; i++) {
+      var span = spans[i];
+      span.title = hit.count;
+      span.classList.remove("uncovered");
+      span.style.transition = "none";
+      span.style.backgroundColor = "#a0a000";
+      span.offsetWidth; // restart the transition
+      span.style.transition = "background-color " + fade + "ms ease-out";
+      span.style.backgroundColor = "#005000";
+    }
+  };
+})();
+</script>
+`, fade.Nanoseconds()/1e6, file, name, scopeName)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// runExport implements "fullcover export": it stores the report of a session
+// as one HTML file which can be browsed without the daemon.
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	daemonAddress := flags.String("connection", "", "how to reach the sidechannel daemon")
+	sessionName := flags.String("session", "", "session to export, default is the current one")
+	scope := flags.String("scope", "", "only export coverage reached in this scope")
+	test := flags.String("test", "", "only export coverage reached by this test")
+	output := flags.String("o", "coverage.html", "file to write the report to")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage of 'go tool fullcover export':
+Write the coverage collected by the daemon as a static HTML report
+	go tool fullcover export -connection 'localhost:10001' -o coverage.html`)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *daemonAddress == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	query := url.Values{}
+	query.Set("session", *sessionName)
+	query.Set("scope", *scope)
+	query.Set("test", *test)
+
+	resp, err := http.Get("http://" + *daemonAddress + "/export?" + query.Encode())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "fullcover: %s: %s", resp.Status, body)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(*output, body, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// handleExport serves the report of the requested session, scope or test as
+// a single HTML file on GET /export.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="coverage.html"`)
+	writeExport(w, s, r.URL.Query().Get("scope"), r.URL.Query().Get("test"))
+}
+
+// writeExport writes the file tree, the functions and all source views of
+// session s as one page. Every source view is embedded as a document of its
+// own, so line anchors and navigation work as when served by the daemon, and
+// is shown when its section is the target of the location's hash.
+// The caller must hold countsLock.
+func writeExport(w io.Writer, s *session, scope, test string) {
+	counts := s.viewCounts(scope, test)
+	owners := s.tests.owners()
+
+	filenames := sortedFilenames()
+	anchors := make(map[string]string)
+	for i, filename := range filenames {
+		anchors[filename] = fmt.Sprintf("file-%d", i)
+	}
+	link := func(filename string) string {
+		return "#" + anchors[filename]
+	}
+
+	view := html.EscapeString(s.name)
+	if scope != "" {
+		view += ", scope " + html.EscapeString(scope)
+	}
+	if test != "" {
+		view += ", test " + html.EscapeString(test)
+	}
+
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head>
+<meta charset="utf-8">
+<title>Coverage of %s</title>
+<style>
+section.file { display: none; }
+section.file:target { display: block; }
+section.file iframe { width: 100%%; height: 85vh; border: none; }
+</style>
+</head><body>
+  <p>Session: %s, exported %s</p>
+`, view, view, time.Now().Format(time.RFC1123))
+
+	filter := indexFilter{sort: "name"}
+	root := buildTree(counts, filter)
+	fmt.Fprintf(w, "  <p>Total: %s</p>\n", formatCoverage(root.coveredStmt, root.totalStmt))
+	writeTree(w, root, filter, link)
+
+	var summaries []functionSummary
+	for _, filename := range filenames {
+		fileSummaries, err := summarizeFunctions(filename, sources[filename], counts[filename])
+		if err != nil {
+			fmt.Fprintf(w, "  <p>%s: %s</p>\n", html.EscapeString(filename), html.EscapeString(err.Error()))
+			continue
+		}
+		summaries = append(summaries, fileSummaries...)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return functionOrders["coverage"](summaries[i], summaries[j])
+	})
+
+	fmt.Fprintf(w, `  <table border="1">
+	<tr><th>file</th><th>function</th><th>coverage</th><th>statements</th><th>calls</th></tr>
+`)
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "\t<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td><td>%d</td><td>%d</td></tr>\n",
+			link(summary.file), html.EscapeString(summary.file), summary.function.name,
+			formatCoverage(summary.coveredStmt, summary.totalStmt), summary.totalStmt, summary.calls)
+	}
+	fmt.Fprintf(w, "  </table>\n")
+
+	for _, filename := range filenames {
+		fileCounts := counts[filename]
+		max := maxCount(fileCounts)
+
+		var page bytes.Buffer
+		fmt.Fprintf(&page, `<!DOCTYPE html>
+<html><head>
+<meta charset="utf-8">
+</head><body style="background-color: black; color: white;">
+`)
+		renderSource(&page, sources[filename], fileCounts, sourceColor(s, filename, max, owners), survivingMutants(filename))
+		fmt.Fprintf(&page, `
+</body></html>
+`)
+
+		coveredStmt, totalStmt := statementCoverage(fileCounts)
+		fmt.Fprintf(w, `  <section class="file" id="%s">
+    <p>%s (%s, <a href="#">back to the index</a>)</p>
+    <iframe srcdoc="%s"></iframe>
+  </section>
+`, anchors[filename], html.EscapeString(filename), formatCoverage(coveredStmt, totalStmt), html.EscapeString(page.String()))
+	}
+
+	fmt.Fprintf(w, `
+</body></html>
+`)
+}
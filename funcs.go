@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html"
+	"net/http"
+	"sort"
+)
+
+// function is the extent of a function declaration in a source.
+type function struct {
+	name      string // "Name" or "Type.Name" for methods
+	startLine int
+	startCol  int
+	endLine   int
+	endCol    int
+}
+
+// parseSource parses a reported source. Sources reported by instrumented
+// programs compiled, so errors are unlikely.
+func parseSource(filename, source string) (*ast.File, *token.FileSet, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, source, 0)
+	return file, fset, err
+}
+
+// functions lists the function declarations of file in source order.
+func functions(file *ast.File, fset *token.FileSet) []function {
+	var result []function
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+
+		name := fn.Name.Name
+		if fn.Recv != nil && len(fn.Recv.List) > 0 {
+			name = receiverType(fn.Recv.List[0].Type) + "." + name
+		}
+
+		start := fset.Position(fn.Pos())
+		end := fset.Position(fn.End())
+		result = append(result, function{
+			name:      name,
+			startLine: start.Line,
+			startCol:  start.Column,
+			endLine:   end.Line,
+			endCol:    end.Column,
+		})
+	}
+	return result
+}
+
+// receiverType names the type of a method receiver, without pointer and type parameters.
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.ParenExpr:
+		return receiverType(t.X)
+	case *ast.IndexExpr:
+		return receiverType(t.X)
+	case *ast.IndexListExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
+
+// functionOf returns the function containing the position, or nil.
+func functionOf(fns []function, line, col int) *function {
+	if i := functionIndex(fns, line, col); i >= 0 {
+		return &fns[i]
+	}
+	return nil
+}
+
+// functionIndex returns the index of the function containing the position, or -1.
+func functionIndex(fns []function, line, col int) int {
+	for i, fn := range fns {
+		if before(fn.startLine, fn.startCol, line, col) && before(line, col, fn.endLine, fn.endCol) {
+			return i
+		}
+	}
+	return -1
+}
+
+// before reports whether line1:col1 is not after line2:col2.
+func before(line1, col1, line2, col2 int) bool {
+	return line1 < line2 || (line1 == line2 && col1 <= col2)
+}
+
+// functionSummary is the coverage of one function.
+type functionSummary struct {
+	file        string
+	function    function
+	coveredStmt int
+	totalStmt   int
+	calls       int // executions of the function's first block
+}
+
+// summarizeFunctions maps the blocks of filename to the functions containing them.
+func summarizeFunctions(filename, source string, fileCounts map[int]map[int]*block) ([]functionSummary, error) {
+	file, fset, err := parseSource(filename, source)
+	if err != nil {
+		return nil, err
+	}
+
+	fns := functions(file, fset)
+	result := make([]functionSummary, len(fns))
+	for i, fn := range fns {
+		result[i] = functionSummary{file: filename, function: fn}
+	}
+
+	seen := make([]bool, len(fns))
+	for _, b := range sortedBlocks(fileCounts) {
+		i := functionIndex(fns, b.startLine, b.startCol)
+		if i < 0 {
+			continue
+		}
+
+		summary := &result[i]
+		if !seen[i] {
+			seen[i] = true
+			summary.calls = b.count
+		}
+
+		summary.totalStmt += b.numStmt
+		if b.count > 0 {
+			summary.coveredStmt += b.numStmt
+		}
+	}
+	return result, nil
+}
+
+// ratio returns the covered fraction of the function's statements, 1 if it has none.
+func (s functionSummary) ratio() float64 {
+	if s.totalStmt == 0 {
+		return 1
+	}
+	return float64(s.coveredStmt) / float64(s.totalStmt)
+}
+
+// functionOrders sort function summaries as selected by the "sort" query parameter.
+var functionOrders = map[string]func(a, b functionSummary) bool{
+	"name": func(a, b functionSummary) bool {
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.function.startLine < b.function.startLine
+	},
+	"function": func(a, b functionSummary) bool {
+		return a.function.name < b.function.name
+	},
+	"coverage": func(a, b functionSummary) bool {
+		return a.ratio() < b.ratio()
+	},
+	"statements": func(a, b functionSummary) bool {
+		return a.totalStmt > b.totalStmt
+	},
+	"calls": func(a, b functionSummary) bool {
+		return a.calls > b.calls
+	},
+}
+
+// handleFunctions shows the coverage of every function of the requested
+// session (and scope or test), sorted by the "sort" query parameter.
+func handleFunctions(w http.ResponseWriter, r *http.Request) {
+	order, ok := functionOrders[r.URL.Query().Get("sort")]
+	if !ok {
+		order = functionOrders["name"]
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	test := r.URL.Query().Get("test")
+	counts := s.viewCounts(scope, test)
+	query := viewQuery(s, scope, test)
+
+	var summaries []functionSummary
+	var failed []string
+	for _, filename := range sortedFilenames() {
+		fileSummaries, err := summarizeFunctions(filename, sources[filename], counts[filename])
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", filename, err))
+			continue
+		}
+		summaries = append(summaries, fileSummaries...)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return order(summaries[i], summaries[j])
+	})
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>Functions of session %s (<a href="/?%s">files</a>)</p>
+`, html.EscapeString(s.name), query)
+	for _, failure := range failed {
+		fmt.Fprintf(w, "  <p>%s</p>\n", html.EscapeString(failure))
+	}
+	fmt.Fprintf(w, `  <table border="1">
+	<tr><th><a href="/functions?%[1]s&sort=name">file</a></th><th><a href="/functions?%[1]s&sort=function">function</a></th><th><a href="/functions?%[1]s&sort=coverage">coverage</a></th><th><a href="/functions?%[1]s&sort=statements">statements</a></th><th><a href="/functions?%[1]s&sort=calls">calls</a></th></tr>
+`, query)
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "\t<tr><td>%s</td><td><a href=\"%s#L%d\">%s</a></td><td>%s</td><td>%d</td><td>%d</td></tr>\n",
+			html.EscapeString(summary.file), html.EscapeString(fileURL("/", summary.file)+"?"+query), summary.function.startLine, summary.function.name,
+			formatCoverage(summary.coveredStmt, summary.totalStmt), summary.totalStmt, summary.calls)
+	}
+	fmt.Fprintf(w, `
+  </table>
+</body></html>
+`)
+}
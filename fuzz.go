@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runFuzz implements "fullcover fuzz": it mutates raw HTTP requests, sends
+// them to the target and keeps those executing code for the first time.
+func runFuzz(args []string) {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	daemonAddress := flags.String("connection", "", "how to reach the sidechannel daemon")
+	target := flags.String("target", "", "host:port of the instrumented HTTP service")
+	seeds := flags.String("seeds", "", "directory of raw HTTP requests to start from")
+	corpus := flags.String("corpus", "corpus", "directory to keep coverage-increasing requests in")
+	crashes := flags.String("crashes", "crashes", "directory to keep crashing requests in")
+	iterations := flags.Int("iterations", 0, "number of requests to send, 0 for no limit")
+	settle := flags.Duration("settle", 100*time.Millisecond, "time to wait for coverage to arrive at the daemon after a request")
+	timeout := flags.Duration("timeout", 10*time.Second, "time to wait for a response")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage of 'go tool fullcover fuzz':
+Mutate HTTP requests, keeping those which increase coverage
+	go tool fullcover fuzz -connection 'localhost:10001' -target 'localhost:8080' -seeds seeds/`)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *daemonAddress == "" || *target == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	for _, dir := range []string{*corpus, *crashes} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var inputs [][]byte
+	for _, dir := range []string{*seeds, *corpus} {
+		if dir == "" {
+			continue
+		}
+
+		loaded, err := readCorpus(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+		inputs = append(inputs, loaded...)
+	}
+
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "fullcover: no seed requests found")
+		os.Exit(1)
+	}
+
+	firsts, err := fetchFirsts(*daemonAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+
+	f := &fuzzer{
+		target: *target,
+		inputs: inputs,
+		client: &http.Client{
+			Timeout: *timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i := 0; *iterations == 0 || i < *iterations; i++ {
+		input := f.mutate(f.inputs[f.rand.Intn(len(f.inputs))])
+
+		crash, err := f.send(input)
+		if err != nil {
+			continue
+		}
+
+		if crash != "" {
+			path, err := storeInput(*crashes, input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("crash (%s): %s\n", crash, path)
+		}
+
+		time.Sleep(*settle)
+
+		newFirsts, err := fetchFirsts(*daemonAddress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+
+		if newFirsts > firsts {
+			path, err := storeInput(*corpus, input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%d new blocks covered: %s\n", newFirsts-firsts, path)
+
+			f.inputs = append(f.inputs, input)
+			firsts = newFirsts
+		}
+	}
+}
+
+// fuzzer holds the state of one fuzzing run.
+type fuzzer struct {
+	target string
+	inputs [][]byte
+	client *http.Client
+	rand   *rand.Rand
+}
+
+// readCorpus loads all requests stored in dir.
+func readCorpus(dir string) ([][]byte, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result [][]byte
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := parseRequest(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(dir, entry.Name()), err)
+		}
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// storeInput saves a request into dir, named by its hash.
+func storeInput(dir string, input []byte) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%x", sha1.Sum(input)))
+	return path, ioutil.WriteFile(path, input, 0644)
+}
+
+// fetchFirsts asks the daemon how many blocks were covered for the first time so far.
+func fetchFirsts(daemonAddress string) (int, error) {
+	resp, err := http.Get("http://" + daemonAddress + "/firsts")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(body)))
+}
+
+// parseRequest reads a raw HTTP request including its body.
+func parseRequest(raw []byte) (*http.Request, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// send delivers a request to the target. It returns why the request counts as
+// a crash, if it does, or an error if it could not be sent at all.
+func (f *fuzzer) send(input []byte) (string, error) {
+	req, err := parseRequest(input)
+	if err != nil {
+		return "", err
+	}
+
+	req.RequestURI = ""
+	req.URL.Scheme = "http"
+	req.URL.Host = f.target
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "", err
+		}
+		return fmt.Sprintf("connection dropped: %v", err), nil
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 500 {
+		return resp.Status, nil
+	}
+	return "", nil
+}
+
+// mutate returns a randomly changed copy of the request input. The request
+// stays well-formed: mutations apply to the path, query, header values or body.
+func (f *fuzzer) mutate(input []byte) []byte {
+	req, err := parseRequest(input)
+	if err != nil {
+		return input
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+
+	for n := 1 + f.rand.Intn(4); n > 0; n-- {
+		switch f.rand.Intn(4) {
+		case 0:
+			req.URL.Path = string(f.mutateBytes([]byte(req.URL.Path)))
+		case 1:
+			req.URL.RawQuery = string(f.mutateBytes([]byte(req.URL.RawQuery)))
+		case 2:
+			if len(req.Header) == 0 {
+				break
+			}
+
+			var names []string
+			for name := range req.Header {
+				names = append(names, name)
+			}
+			name := names[f.rand.Intn(len(names))]
+			req.Header.Set(name, string(f.mutateBytes([]byte(req.Header.Get(name)))))
+		case 3:
+			body = f.mutateBytes(body)
+		}
+	}
+
+	// DumpRequest writes the request as it was received, so update what was parsed.
+	req.RequestURI = ""
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.Header.Del("Transfer-Encoding")
+	if len(body) > 0 {
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	} else {
+		req.Header.Del("Content-Length")
+	}
+
+	result, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		return input
+	}
+	return result
+}
+
+// interestingBytes are likely to trigger special cases in parsers.
+var interestingBytes = []byte("\x00\xff\"'<>{}[]/\\%&=?;:,.-+0123456789 \n")
+
+// mutateBytes applies one random change to data.
+func (f *fuzzer) mutateBytes(data []byte) []byte {
+	result := append([]byte{}, data...)
+
+	if len(result) == 0 {
+		return append(result, interestingBytes[f.rand.Intn(len(interestingBytes))])
+	}
+
+	pos := f.rand.Intn(len(result))
+	switch f.rand.Intn(6) {
+	case 0: // flip a bit
+		result[pos] ^= 1 << uint(f.rand.Intn(8))
+	case 1: // replace by an interesting byte
+		result[pos] = interestingBytes[f.rand.Intn(len(interestingBytes))]
+	case 2: // insert an interesting byte
+		result = append(result[:pos], append([]byte{interestingBytes[f.rand.Intn(len(interestingBytes))]}, result[pos:]...)...)
+	case 3: // delete a range
+		end := pos + 1 + f.rand.Intn(len(result)-pos)
+		result = append(result[:pos], result[end:]...)
+	case 4: // duplicate a range
+		end := pos + 1 + f.rand.Intn(len(result)-pos)
+		chunk := append([]byte{}, result[pos:end]...)
+		result = append(result[:end], append(chunk, result[end:]...)...)
+	case 5: // splice in the body of another input
+		other, err := parseRequest(f.inputs[f.rand.Intn(len(f.inputs))])
+		if err != nil {
+			break
+		}
+		otherBody, _ := ioutil.ReadAll(other.Body)
+		result = append(result[:pos], otherBody...)
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// loadGroup merges the coverage of the comma-separated sessions or snapshots
+// in names, as collected from a group of instances. The caller must not hold
+// countsLock.
+func loadGroup(names string) (map[string]map[int]map[int]*block, map[string]string, error) {
+	if names == "" {
+		return nil, nil, fmt.Errorf("no sessions or snapshots given")
+	}
+
+	type loaded struct {
+		s       *session
+		sources map[string]string
+	}
+	var group []loaded
+	for _, name := range strings.Split(names, ",") {
+		s, groupSources, err := loadCoverage(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		group = append(group, loaded{s, groupSources})
+	}
+
+	merged := &session{counts: make(map[string]map[int]map[int]*block)}
+	mergedSources := make(map[string]string)
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+	for _, member := range group {
+		for filename, source := range member.sources {
+			mergedSources[filename] = source
+		}
+		for filename, lineCounts := range member.s.counts {
+			for _, colCounts := range lineCounts {
+				for _, b := range colCounts {
+					merged.addBlock(filename, b.startLine, b.startCol, b.endLine, b.endCol, b.numStmt).count += b.count
+				}
+			}
+		}
+	}
+
+	return merged.counts, mergedSources, nil
+}
+
+// handleGaps lists per file the blocks executed in production but never by the
+// tests, and those only the tests execute, on
+// GET /gaps?production=<names>&tests=<names>, and shows the overlay of one
+// file on GET /gaps/<file>?production=<names>&tests=<names>. Both parameters
+// take comma-separated sessions or snapshots.
+func handleGaps(w http.ResponseWriter, r *http.Request) {
+	production, productionSources, err := loadGroup(r.URL.Query().Get("production"))
+	if err != nil {
+		http.Error(w, "production: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tests, testSources, err := loadGroup(r.URL.Query().Get("tests"))
+	if err != nil {
+		http.Error(w, "tests: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := comparisonPage{
+		path: "/gaps",
+		query: fmt.Sprintf("production=%s&tests=%s",
+			url.QueryEscape(r.URL.Query().Get("production")), url.QueryEscape(r.URL.Query().Get("tests"))),
+		title: fmt.Sprintf("Coverage gaps between production (%s) and tests (%s)",
+			html.EscapeString(r.URL.Query().Get("production")), html.EscapeString(r.URL.Query().Get("tests"))),
+		legend:  `<span style="background-color: #604000">production only</span>, <span style="background-color: #003060">tests only</span>, <span style="background-color: #005000">both</span>, <span style="background-color: #600000">neither</span>`,
+		columns: []string{"production only", "tests only"},
+
+		filenames: unionFilenames(productionSources, testSources),
+		sources:   productionSources,
+		counts:    production,
+
+		entries: func(filename string) [][]string {
+			return [][]string{
+				executedOnlyBy(production[filename], tests[filename]),
+				executedOnlyBy(tests[filename], production[filename]),
+			}
+		},
+		color: func(filename string, b *block) string {
+			return gapColor(b, lookupBlock(tests[filename], b.startLine, b.startCol))
+		},
+		omitEmpty: true,
+	}
+	page.serve(w, r)
+}
+
+// executedOnlyBy describes the blocks executed in counts but not in other.
+func executedOnlyBy(counts, other map[int]map[int]*block) []string {
+	var result []string
+	for _, b := range sortedBlocks(counts) {
+		if b.count > 0 && lookupBlock(other, b.startLine, b.startCol).countOrZero() == 0 {
+			result = append(result, fmt.Sprintf("%d.%d-%d.%d (%d)", b.startLine, b.startCol, b.endLine, b.endCol, b.count))
+		}
+	}
+	return result
+}
+
+// gapColor styles a block by whether production, the tests, both or neither execute it.
+func gapColor(production, tests *block) string {
+	title := fmt.Sprintf("production %d, tests %d", production.countOrZero(), tests.countOrZero())
+
+	color := "#600000"
+	switch {
+	case production.countOrZero() > 0 && tests.countOrZero() > 0:
+		color = "#005000"
+	case production.countOrZero() > 0:
+		color = "#604000"
+	case tests.countOrZero() > 0:
+		color = "#003060"
+	}
+	return fmt.Sprintf(`</span><span style="background-color: %s" title="%s">`, color, title)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// blockHistory is what the daemon remembers about a block across restarts.
+type blockHistory struct {
+	firstReported time.Time // when the block was first reported to exist
+	lastExecuted  time.Time // zero if never
+}
+
+// histories holds the history of every block ever reported, protected by countsLock.
+var histories = make(map[blockKey]*blockHistory)
+
+// historyEntry is the on-disk representation of a block's history.
+type historyEntry struct {
+	File          string    `json:"file"`
+	Line          int       `json:"line"`
+	Col           int       `json:"col"`
+	FirstReported time.Time `json:"firstReported"`
+	LastExecuted  time.Time `json:"lastExecuted"`
+}
+
+const historyInterval = time.Minute
+
+// recordReported notes that a block exists. The caller must hold countsLock.
+func recordReported(key blockKey, now time.Time) {
+	if histories[key] == nil {
+		histories[key] = &blockHistory{firstReported: now}
+	}
+}
+
+// recordExecuted notes that a block was executed. The caller must hold countsLock.
+func recordExecuted(key blockKey, now time.Time) {
+	recordReported(key, now)
+	histories[key].lastExecuted = now
+}
+
+// forgetUnreported drops the history of the blocks of filename not in
+// reported, which holds all blocks reported along with a new report of its
+// source. The caller must hold countsLock.
+func forgetUnreported(filename string, reported map[blockKey]bool) {
+	for key := range histories {
+		if key.file == filename && !reported[key] {
+			delete(histories, key)
+		}
+	}
+}
+
+// loadHistory reads the history file, if one is configured and exists.
+func loadHistory() error {
+	if *history == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(*history)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var entries []historyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	for _, e := range entries {
+		histories[blockKey{file: e.File, line: e.Line, col: e.Col}] = &blockHistory{
+			firstReported: e.FirstReported,
+			lastExecuted:  e.LastExecuted,
+		}
+	}
+	return nil
+}
+
+// saveHistory writes the history file, if one is configured.
+func saveHistory() error {
+	if *history == "" {
+		return nil
+	}
+
+	countsLock.Lock()
+	entries := []historyEntry{}
+	for key, h := range histories {
+		entries = append(entries, historyEntry{
+			File:          key.file,
+			Line:          key.line,
+			Col:           key.col,
+			FirstReported: h.firstReported,
+			LastExecuted:  h.lastExecuted,
+		})
+	}
+	countsLock.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write the new history next to the old one, so a crash cannot lose both.
+	if err := ioutil.WriteFile(*history+".new", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(*history+".new", *history)
+}
+
+// keepHistory saves the history file regularly.
+func keepHistory() {
+	for range time.Tick(historyInterval) {
+		if err := saveHistory(); err != nil {
+			log.Printf("saving history: %v", err)
+		}
+	}
+}
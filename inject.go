@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// injectionSite is a call whose error result can be replaced at runtime.
+type injectionSite struct {
+	line       int
+	col        int
+	branchLine int // start of the "if err != nil" block handling the error, 0 if unknown
+	branchCol  int
+	call       string
+}
+
+const injectedErrName = "_cover_err_"
+
+// addInjections inserts a hook after every call assigning to an error variable,
+// through which the daemon can replace the error returned.
+func (f *File) addInjections() {
+	done := make(map[*ast.AssignStmt]bool)
+	results := f.errorResults()
+
+	ast.Inspect(f.astFile, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.BlockStmt:
+			n.List = f.injectList(n.List, done, results)
+		case *ast.CaseClause:
+			n.Body = f.injectList(n.Body, done, results)
+		case *ast.CommClause:
+			n.Body = f.injectList(n.Body, done, results)
+		}
+		return true
+	})
+}
+
+// injectList adds hooks to the injectable calls of one statement list.
+func (f *File) injectList(list []ast.Stmt, done map[*ast.AssignStmt]bool, results map[string]bool) []ast.Stmt {
+	var newList []ast.Stmt
+	for i, stmt := range list {
+		switch s := stmt.(type) {
+		case *ast.AssignStmt:
+			errName, call := injectableCall(s, results)
+			if call == nil || done[s] {
+				break
+			}
+			done[s] = true
+
+			newList = append(newList, s, f.newInjection(s, errName, call, f.nextStatement(list[i+1:])))
+			continue
+
+		case *ast.IfStmt:
+			init, ok := s.Init.(*ast.AssignStmt)
+			if !ok {
+				break
+			}
+
+			errName, call := injectableCall(init, results)
+			if call == nil || done[init] {
+				break
+			}
+			done[init] = true
+
+			// The hook has to run between the initialization and the condition,
+			// so move both into a block of their own:
+			//	{
+			//		x, err := f()
+			//		<hook>
+			//		if err != nil {
+			//		}
+			//	}
+			// This keeps the scope of the initialized variables limited to the if.
+			s.Init = nil
+			newList = append(newList, &ast.BlockStmt{
+				List: []ast.Stmt{init, f.newInjection(init, errName, call, s), s},
+			})
+			continue
+		}
+
+		newList = append(newList, stmt)
+	}
+	return newList
+}
+
+// injectableCall returns the error variable and the call if s is of the form
+//
+//	a, b, err := f(...)
+//
+// or
+//
+//	err = f(...)
+//
+// where the last result of f is an error. This is known from the declared
+// results if f is a function of this file, see errorResults, and otherwise
+// guessed from the variable's name.
+func injectableCall(s *ast.AssignStmt, results map[string]bool) (string, *ast.CallExpr) {
+	if len(s.Rhs) != 1 || (s.Tok != token.DEFINE && s.Tok != token.ASSIGN) {
+		return "", nil
+	}
+
+	call, ok := s.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return "", nil
+	}
+
+	ident, ok := s.Lhs[len(s.Lhs)-1].(*ast.Ident)
+	if !ok || ident.Name == "_" {
+		return "", nil
+	}
+
+	if fun, ok := call.Fun.(*ast.Ident); ok {
+		if isError, declared := results[fun.Name]; declared {
+			if !isError {
+				return "", nil
+			}
+			return ident.Name, call
+		}
+	}
+	if !isErrorName(ident.Name) {
+		return "", nil
+	}
+
+	// Constructing an error is not a failure that could be injected.
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			if (pkg.Name == "errors" && sel.Sel.Name == "New") || (pkg.Name == "fmt" && sel.Sel.Name == "Errorf") {
+				return "", nil
+			}
+		}
+	}
+
+	return ident.Name, call
+}
+
+// errorResults tells for the functions declared in the file whether their last
+// result is of type error. The rewriter sees a single file without type
+// information, so calls to functions declared elsewhere are not covered.
+func (f *File) errorResults() map[string]bool {
+	results := make(map[string]bool)
+	for _, decl := range f.astFile.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+
+		isError := false
+		if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+			last, ok := fn.Type.Results.List[len(fn.Type.Results.List)-1].Type.(*ast.Ident)
+			isError = ok && last.Name == "error"
+		}
+		results[fn.Name.Name] = isError
+	}
+	return results
+}
+
+// isErrorName guesses from its name whether a variable holds an error. Without
+// type checking we cannot know for sure.
+func isErrorName(name string) bool {
+	return name == "err" || strings.HasSuffix(name, "Err")
+}
+
+// nextStatement returns the first statement of list which is not a counter.
+func (f *File) nextStatement(list []ast.Stmt) ast.Stmt {
+	for _, stmt := range list {
+		if !isCounter(stmt) {
+			return stmt
+		}
+	}
+	return nil
+}
+
+// isCounter reports whether stmt is a counter added by newCounter.
+func isCounter(stmt ast.Stmt) bool {
+	if expr, ok := stmt.(*ast.ExprStmt); ok {
+		if call, ok := expr.X.(*ast.CallExpr); ok {
+			if ident, ok := call.Fun.(*ast.Ident); ok && ident.Name == *coverCall {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newInjection creates the hook for the call in s, which is of the form
+//
+//	if _cover_err_ := InjectError(..., &a, &b); _cover_err_ != nil {
+//		err = _cover_err_
+//	}
+//
+// next is the statement following the call, which might be the branch handling the error.
+func (f *File) newInjection(s *ast.AssignStmt, errName string, call *ast.CallExpr, next ast.Stmt) ast.Stmt {
+	pos := f.fset.Position(call.Pos())
+	site := injectionSite{
+		line: pos.Line,
+		col:  pos.Column,
+	}
+
+	var fun bytes.Buffer
+	printer.Fprint(&fun, f.fset, call.Fun)
+	site.call = fun.String()
+
+	if ifStmt, ok := next.(*ast.IfStmt); ok && checksError(ifStmt.Cond, errName) {
+		branch := f.fset.Position(ifStmt.Body.Lbrace)
+		site.branchLine = branch.Line
+		site.branchCol = branch.Column
+	}
+	f.sites = append(f.sites, site)
+
+	args := []ast.Expr{
+		f.stringLiteral(*connection),
+		f.stringLiteral(*sourceName),
+		f.intLiteral(site.line),
+		f.intLiteral(site.col),
+		f.intLiteral(site.branchLine),
+		f.intLiteral(site.branchCol),
+	}
+	for _, lhs := range s.Lhs[:len(s.Lhs)-1] {
+		if ident, ok := lhs.(*ast.Ident); ok && ident.Name != "_" {
+			args = append(args, &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(ident.Name)})
+		}
+	}
+
+	return &ast.IfStmt{
+		Init: &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(injectedErrName)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent(*injectCall), Args: args}},
+		},
+		Cond: &ast.BinaryExpr{
+			X:  ast.NewIdent(injectedErrName),
+			Op: token.NEQ,
+			Y:  ast.NewIdent("nil"),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent(errName)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{ast.NewIdent(injectedErrName)},
+			}},
+		},
+	}
+}
+
+// checksError reports whether cond is "errName != nil".
+func checksError(cond ast.Expr, errName string) bool {
+	binary, ok := cond.(*ast.BinaryExpr)
+	if !ok || binary.Op != token.NEQ {
+		return false
+	}
+
+	x, ok := binary.X.(*ast.Ident)
+	if !ok || x.Name != errName {
+		return false
+	}
+
+	y, ok := binary.Y.(*ast.Ident)
+	return ok && y.Name == "nil"
+}
+
+// arming is the daemon's state of one injection site.
+type arming struct {
+	call      string
+	remaining int
+	message   string
+	zero      bool
+}
+
+type siteKey struct {
+	file string
+	line int
+	col  int
+}
+
+// injectionSites holds all reported and armed sites, protected by countsLock.
+var injectionSites = make(map[siteKey]*arming)
+
+// handleInject arms sites on POST /inject/arm, tells senders which sites are
+// armed on GET /inject/armed and hands out injected errors on POST /inject/take.
+func handleInject(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	switch r.URL.Path {
+	case "/inject/armed":
+		type armedSite struct {
+			File string `json:"file"`
+			Line int    `json:"line"`
+			Col  int    `json:"col"`
+		}
+
+		armed := []armedSite{}
+		for key, a := range injectionSites {
+			if a.remaining > 0 {
+				armed = append(armed, armedSite{File: key.file, Line: key.line, Col: key.col})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(armed)
+		return
+
+	case "/inject/arm", "/inject/take":
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := requestedSite(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a := injectionSites[key]
+	if r.URL.Path == "/inject/arm" {
+		if a == nil {
+			http.Error(w, fmt.Sprintf("no injection site reported at %s:%d:%d", key.file, key.line, key.col), http.StatusNotFound)
+			return
+		}
+
+		count := 1
+		if value := r.FormValue("count"); value != "" {
+			var err error
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 0 {
+				http.Error(w, fmt.Sprintf("invalid count %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		message := r.FormValue("message")
+		if message == "" {
+			message = "error injected by fullcover"
+		}
+
+		a.remaining = count
+		a.message = message
+		a.zero = r.FormValue("zero") != ""
+
+		if strings.HasPrefix(r.FormValue("redirect"), "/") {
+			http.Redirect(w, r, r.FormValue("redirect"), http.StatusSeeOther)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s:%d:%d armed for %d calls\n", key.file, key.line, key.col, count)
+		return
+	}
+
+	if a == nil || a.remaining <= 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	a.remaining--
+
+	branchLine, _ := strconv.Atoi(r.FormValue("branchLine"))
+	branchCol, _ := strconv.Atoi(r.FormValue("branchCol"))
+	if branchLine > 0 {
+		current.addInjected(key.file, branchLine, branchCol)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+		Zero    bool   `json:"zero"`
+	}{a.message, a.zero})
+}
+
+// requestedSite parses the file, line and col form values.
+func requestedSite(r *http.Request) (siteKey, error) {
+	line, err := strconv.Atoi(r.FormValue("line"))
+	if err != nil {
+		return siteKey{}, fmt.Errorf("invalid line: %v", err)
+	}
+
+	col, err := strconv.Atoi(r.FormValue("col"))
+	if err != nil {
+		return siteKey{}, fmt.Errorf("invalid col: %v", err)
+	}
+
+	return siteKey{file: r.FormValue("file"), line: line, col: col}, nil
+}
+
+// addInjected counts an execution of the error branch starting at the given
+// position which was caused by an injected error.
+func (s *session) addInjected(filename string, line, col int) {
+	if s.injected[filename] == nil {
+		s.injected[filename] = make(map[int]map[int]int)
+	}
+
+	if s.injected[filename][line] == nil {
+		s.injected[filename][line] = make(map[int]int)
+	}
+
+	s.injected[filename][line][col]++
+}
+
+// onlyInjected reports whether all executions of b were caused by injected errors.
+func (s *session) onlyInjected(filename string, b *block) bool {
+	return b.count > 0 && b.count <= s.injected[filename][b.startLine][b.startCol]
+}
+
+// injectedColor styles a block only reached through injected errors.
+func injectedColor(b *block) string {
+	return fmt.Sprintf(`</span><span data-block="%d:%d" style="background-color: #604000" title="%d, only through injected errors">`,
+		b.startLine, b.startCol, b.count)
+}
+
+// writeInjectionSites lists the injection sites of filename with forms to arm them.
+// The caller must hold countsLock.
+func writeInjectionSites(w http.ResponseWriter, filename, page string) {
+	var keys []siteKey
+	for key := range injectionSites {
+		if key.file == filename {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].line != keys[j].line {
+			return keys[i].line < keys[j].line
+		}
+		return keys[i].col < keys[j].col
+	})
+
+	fmt.Fprintf(w, `
+<table>
+`)
+	for _, key := range keys {
+		a := injectionSites[key]
+		fmt.Fprintf(w, `	<tr><td>%d:%d %s</td><td>%d armed</td><td><form method="post" action="/inject/arm">
+		<input type="hidden" name="file" value="%s">
+		<input type="hidden" name="line" value="%d">
+		<input type="hidden" name="col" value="%d">
+		<input type="hidden" name="redirect" value="%s">
+		<input name="count" value="1" size="3">
+		<input name="message" value="%s">
+		<label><input type="checkbox" name="zero" checked>zero other results</label>
+		<input type="submit" value="arm">
+	</form></td></tr>
+`, key.line, key.col, html.EscapeString(a.call), a.remaining, html.EscapeString(filename), key.line, key.col, html.EscapeString(page), "error injected by fullcover")
+	}
+	fmt.Fprintf(w, `</table>
+`)
+}
+
+// collectInjectionSite records a site reported by an instrumented program.
+func collectInjectionSite(filename string, line, col int, call string) {
+	key := siteKey{file: filename, line: line, col: col}
+
+	countsLock.Lock()
+	if injectionSites[key] == nil {
+		injectionSites[key] = &arming{}
+	}
+	injectionSites[key].call = call
+	countsLock.Unlock()
+}
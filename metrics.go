@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Health of the collection since the daemon started, updated atomically.
+var (
+	connectedSenders int64
+	recordsIngested  int64
+	parseErrors      int64
+	bytesReceived    int64
+)
+
+// countingReader counts the bytes read through it as received from senders.
+type countingReader struct {
+	io.Reader
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	atomic.AddInt64(&bytesReceived, int64(n))
+	return n, err
+}
+
+// recoverParseError ends the collection from a sender whose report could not
+// be parsed, counting it instead of stopping the daemon.
+func recoverParseError() {
+	err := recover()
+	if err == nil {
+		return
+	}
+	if _, ok := err.(parseError); !ok {
+		panic(err)
+	}
+
+	atomic.AddInt64(&parseErrors, 1)
+	log.Printf("dropping sender: %v", err)
+}
+
+// labelEscaper escapes label values for the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetricHeader describes the metric name of type kind.
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// handleMetrics exports the statement coverage of the requested session per
+// file and per package, and the health of the collection, in the Prometheus
+// text format on GET /metrics.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	type coverage struct {
+		covered, total int
+	}
+	files := make(map[string]coverage)
+	packages := make(map[string]coverage)
+	for _, filename := range sortedFilenames() {
+		covered, total := statementCoverage(s.counts[filename])
+		files[filename] = coverage{covered, total}
+
+		pkg := packages[path.Dir(filename)]
+		packages[path.Dir(filename)] = coverage{pkg.covered + covered, pkg.total + total}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	session := labelEscaper.Replace(s.name)
+	for _, group := range []struct {
+		label    string
+		coverage map[string]coverage
+	}{
+		{"file", files},
+		{"package", packages},
+	} {
+		var names []string
+		for name := range group.coverage {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		writeMetricHeader(w, "fullcover_"+group.label+"_statements_covered", "gauge", "Statements executed at least once, per "+group.label+".")
+		for _, name := range names {
+			fmt.Fprintf(w, "fullcover_%s_statements_covered{session=\"%s\",%s=\"%s\"} %d\n",
+				group.label, session, group.label, labelEscaper.Replace(name), group.coverage[name].covered)
+		}
+		writeMetricHeader(w, "fullcover_"+group.label+"_statements", "gauge", "Statements reported, per "+group.label+".")
+		for _, name := range names {
+			fmt.Fprintf(w, "fullcover_%s_statements{session=\"%s\",%s=\"%s\"} %d\n",
+				group.label, session, group.label, labelEscaper.Replace(name), group.coverage[name].total)
+		}
+	}
+
+	writeMetricHeader(w, "fullcover_sources", "gauge", "Source files reported.")
+	fmt.Fprintf(w, "fullcover_sources %d\n", len(sources))
+	writeMetricHeader(w, "fullcover_connected_senders", "gauge", "Senders currently connected.")
+	fmt.Fprintf(w, "fullcover_connected_senders %d\n", atomic.LoadInt64(&connectedSenders))
+	writeMetricHeader(w, "fullcover_records_ingested_total", "counter", "Records received from senders.")
+	fmt.Fprintf(w, "fullcover_records_ingested_total %d\n", atomic.LoadInt64(&recordsIngested))
+	writeMetricHeader(w, "fullcover_parse_errors_total", "counter", "Senders dropped because their report could not be parsed.")
+	fmt.Fprintf(w, "fullcover_parse_errors_total %d\n", atomic.LoadInt64(&parseErrors))
+	writeMetricHeader(w, "fullcover_received_bytes_total", "counter", "Bytes of reports received from senders.")
+	fmt.Fprintf(w, "fullcover_received_bytes_total %d\n", atomic.LoadInt64(&bytesReceived))
+}
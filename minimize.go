@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// minimize greedily selects names of t until their executions include every
+// block executed by any name, returning them in the order selected together
+// with the number of blocks each adds.
+func (t tally) minimize() (selected []string, added []int) {
+	remaining := make(map[string]map[blockKey]bool)
+	for _, name := range t.names() {
+		remaining[name] = make(map[blockKey]bool)
+		for filename, lineCounts := range t[name] {
+			for line, colCounts := range lineCounts {
+				for col := range colCounts {
+					remaining[name][blockKey{file: filename, line: line, col: col}] = true
+				}
+			}
+		}
+	}
+
+	for {
+		best := ""
+		for _, name := range t.names() {
+			if keys, ok := remaining[name]; ok && (best == "" || len(keys) > len(remaining[best])) {
+				best = name
+			}
+		}
+		if best == "" || len(remaining[best]) == 0 {
+			return
+		}
+
+		covered := remaining[best]
+		delete(remaining, best)
+		selected = append(selected, best)
+		added = append(added, len(covered))
+
+		for _, keys := range remaining {
+			for key := range covered {
+				delete(keys, key)
+			}
+		}
+	}
+}
+
+// redundant returns the names of t executing no block that no other name executes.
+func (t tally) redundant() []string {
+	unique := make(map[string]bool)
+	for _, names := range t.owners() {
+		if len(names) == 1 {
+			unique[names[0]] = true
+		}
+	}
+
+	var result []string
+	for _, name := range t.names() {
+		if !unique[name] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// handleMinimize shows a small subset of the tests (or, with "of=scopes", of
+// the scopes) of the requested session which together execute every block
+// executed by all of them, and those which add no unique coverage.
+func handleMinimize(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, kind := s.tests, "tests"
+	if r.URL.Query().Get("of") == "scopes" {
+		t, kind = s.scopes, "scopes"
+	}
+
+	selected, added := t.minimize()
+	total := 0
+	for _, n := range added {
+		total += n
+	}
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>%d of %d %s of session %s execute all %d blocks executed by any of them</p>
+  <table border="1">
+	<tr><th>%s</th><th>blocks added</th></tr>
+`, len(selected), len(t), kind, html.EscapeString(s.name), total, kind)
+	for i, name := range selected {
+		fmt.Fprintf(w, "\t<tr><td>%s</td><td>%d</td></tr>\n", html.EscapeString(name), added[i])
+	}
+	fmt.Fprintf(w, `  </table>
+`)
+
+	if kind == "tests" && len(selected) > 0 {
+		topLevel := make(map[string]bool)
+		var tests []string
+		for _, name := range selected {
+			test := strings.SplitN(name, "/", 2)[0]
+			if !topLevel[test] {
+				topLevel[test] = true
+				tests = append(tests, test)
+			}
+		}
+		fmt.Fprintf(w, "  <p>go test -run '%s'</p>\n", html.EscapeString(testPattern(tests)))
+	}
+
+	fmt.Fprintf(w, `  <p>%s without unique coverage: %s</p>
+</body></html>
+`, kind, html.EscapeString(strings.Join(t.redundant(), ", ")))
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mutation is a change to the program which can be activated at runtime.
+type mutation struct {
+	id          int
+	line        int
+	col         int
+	description string
+}
+
+// addMutants makes the file's function bodies mutable: conditions can be
+// negated, comparisons swapped between < and <= (> and >=), simple statements
+// removed and integer constants within those changed by one.
+func (f *File) addMutants() {
+	generated := make(map[ast.Node]bool)
+
+	for _, decl := range f.astFile.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+
+		// A function with results must end in a terminating statement, which
+		// wrapped into a mutant no longer is.
+		var final ast.Stmt
+		if fn.Type.Results != nil && len(fn.Body.List) > 0 {
+			final = fn.Body.List[len(fn.Body.List)-1]
+		}
+
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			if generated[node] {
+				return false
+			}
+
+			switch n := node.(type) {
+			case *ast.BlockStmt:
+				n.List = f.mutateList(n.List, generated, final)
+			case *ast.CaseClause:
+				n.Body = f.mutateList(n.Body, generated, final)
+			case *ast.CommClause:
+				n.Body = f.mutateList(n.Body, generated, final)
+			case *ast.IfStmt:
+				n.Cond = f.mutateCondition(n.Cond)
+			case *ast.ForStmt:
+				if n.Cond != nil {
+					n.Cond = f.mutateCondition(n.Cond)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// newMutant registers a mutation at pos and returns the expression checking
+// whether it is active.
+func (f *File) newMutant(pos token.Pos, description string) ast.Expr {
+	position := f.fset.Position(pos)
+	m := mutation{
+		id:          len(f.mutants) + 1,
+		line:        position.Line,
+		col:         position.Column,
+		description: description,
+	}
+	f.mutants = append(f.mutants, m)
+
+	return &ast.CallExpr{
+		Fun: ast.NewIdent(*mutantCall),
+		Args: []ast.Expr{
+			f.stringLiteral(*connection),
+			f.stringLiteral(*sourceName),
+			f.intLiteral(m.id),
+		},
+	}
+}
+
+// mutateCondition returns cond extended by its mutants:
+//
+//	bool(mutant(c) && bool(<changed comparison>) || !mutant(c) && bool(cond)) != mutant(n)
+//
+// where n negates the condition. The conversions keep conditions of named
+// boolean types comparable to the mutants.
+func (f *File) mutateCondition(cond ast.Expr) ast.Expr {
+	result := cond
+
+	if found, _ := hasFuncLiteral(cond); !found {
+		for i, binary := range comparisons(cond) {
+			var swapped token.Token
+			switch binary.Op {
+			case token.LSS:
+				swapped = token.LEQ
+			case token.LEQ:
+				swapped = token.LSS
+			case token.GTR:
+				swapped = token.GEQ
+			case token.GEQ:
+				swapped = token.GTR
+			default:
+				continue
+			}
+
+			variant := cloneNode(cond).(ast.Expr)
+			comparisons(variant)[i].Op = swapped
+
+			result = f.mutantSwitch(f.newMutant(binary.OpPos, fmt.Sprintf("%s replaced by %s", binary.Op, swapped)), variant, result)
+		}
+
+		for i, lit := range mutableConstants(cond) {
+			for _, delta := range constantDeltas(lit) {
+				variant := cloneNode(cond).(ast.Expr)
+				changed := mutableConstants(variant)[i]
+				changed.Value = changeConstant(lit, delta)
+
+				result = f.mutantSwitch(f.newMutant(lit.Pos(), fmt.Sprintf("%s replaced by %s", lit.Value, changed.Value)), variant, result)
+			}
+		}
+	}
+
+	return &ast.BinaryExpr{
+		X:  asBool(result),
+		Op: token.NEQ,
+		Y:  f.newMutant(cond.Pos(), "condition negated"),
+	}
+}
+
+// asBool converts the boolean expression e to bool.
+func asBool(e ast.Expr) ast.Expr {
+	return &ast.CallExpr{Fun: ast.NewIdent("bool"), Args: []ast.Expr{e}}
+}
+
+// mutantSwitch returns (active && bool(mutated) || !active && bool(original)).
+func (f *File) mutantSwitch(active, mutated, original ast.Expr) ast.Expr {
+	return &ast.ParenExpr{X: &ast.BinaryExpr{
+		X: &ast.BinaryExpr{
+			X:  active,
+			Op: token.LAND,
+			Y:  asBool(mutated),
+		},
+		Op: token.LOR,
+		Y: &ast.BinaryExpr{
+			X:  &ast.UnaryExpr{Op: token.NOT, X: cloneNode(active).(ast.Expr)},
+			Op: token.LAND,
+			Y:  asBool(original),
+		},
+	}}
+}
+
+// mutateList replaces every removable statement S of list except final by
+//
+//	if mutant(removed) {
+//	} else if mutant(constant changed) {
+//		S'
+//	} else {
+//		S
+//	}
+func (f *File) mutateList(list []ast.Stmt, generated map[ast.Node]bool, final ast.Stmt) []ast.Stmt {
+	var newList []ast.Stmt
+	for _, stmt := range list {
+		if isCounter(stmt) || !isRemovable(stmt) || stmt == final {
+			newList = append(newList, stmt)
+			continue
+		}
+
+		if found, _ := hasFuncLiteral(stmt); found {
+			newList = append(newList, stmt)
+			continue
+		}
+
+		var replacement ast.Stmt = &ast.BlockStmt{List: []ast.Stmt{stmt}}
+		for i, lit := range mutableConstants(stmt) {
+			for _, delta := range constantDeltas(lit) {
+				variant := cloneNode(stmt).(ast.Stmt)
+				changed := mutableConstants(variant)[i]
+				changed.Value = changeConstant(lit, delta)
+
+				replacement = &ast.IfStmt{
+					Cond: f.newMutant(lit.Pos(), fmt.Sprintf("%s replaced by %s", lit.Value, changed.Value)),
+					Body: &ast.BlockStmt{List: []ast.Stmt{variant}},
+					Else: replacement,
+				}
+			}
+		}
+
+		replacement = &ast.IfStmt{
+			Cond: f.newMutant(stmt.Pos(), "statement removed"),
+			Body: &ast.BlockStmt{},
+			Else: replacement,
+		}
+
+		generated[replacement] = true
+		newList = append(newList, replacement)
+	}
+	return newList
+}
+
+// isRemovable reports whether removing stmt leaves a valid program. Calls to
+// panic are kept, as they may terminate a function with results.
+func isRemovable(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		call, ok := s.X.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		name, ok := call.Fun.(*ast.Ident)
+		return !ok || name.Name != "panic"
+	case *ast.IncDecStmt, *ast.SendStmt:
+		return true
+	case *ast.AssignStmt:
+		return s.Tok != token.DEFINE
+	}
+	return false
+}
+
+// comparisons returns the comparisons making up the boolean expression cond,
+// looking through parentheses, !, && and ||.
+func comparisons(cond ast.Expr) []*ast.BinaryExpr {
+	switch e := cond.(type) {
+	case *ast.ParenExpr:
+		return comparisons(e.X)
+	case *ast.UnaryExpr:
+		if e.Op == token.NOT {
+			return comparisons(e.X)
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.LAND, token.LOR:
+			return append(comparisons(e.X), comparisons(e.Y)...)
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return []*ast.BinaryExpr{e}
+		}
+	}
+	return nil
+}
+
+// mutableConstants returns the integer literals of n which can be changed
+// without breaking compilation, i.e. not array lengths, indices or keys of
+// composite literals. Literals within constant expressions and shift counts
+// are left alone as well, as the result may overflow its type.
+func mutableConstants(n ast.Node) []*ast.BasicLit {
+	var result []*ast.BasicLit
+	var visit func(node ast.Node) bool
+	visit = func(node ast.Node) bool {
+		switch e := node.(type) {
+		case *ast.ArrayType:
+			return false
+		case *ast.IndexExpr:
+			ast.Inspect(e.X, visit)
+			return false
+		case *ast.IndexListExpr:
+			ast.Inspect(e.X, visit)
+			return false
+		case *ast.SliceExpr:
+			ast.Inspect(e.X, visit)
+			return false
+		case *ast.KeyValueExpr:
+			ast.Inspect(e.Value, visit)
+			return false
+		case *ast.UnaryExpr:
+			if isConstantExpr(e) {
+				return false
+			}
+		case *ast.BinaryExpr:
+			if isConstantExpr(e) {
+				return false
+			}
+			if e.Op == token.SHL || e.Op == token.SHR {
+				ast.Inspect(e.X, visit)
+				return false
+			}
+		case *ast.BasicLit:
+			if e.Kind == token.INT {
+				result = append(result, e)
+			}
+		}
+		return true
+	}
+	ast.Inspect(n, visit)
+	return result
+}
+
+// isConstantExpr reports whether e is built from literals only.
+func isConstantExpr(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isConstantExpr(e.X)
+	case *ast.UnaryExpr:
+		return isConstantExpr(e.X)
+	case *ast.BinaryExpr:
+		return isConstantExpr(e.X) && isConstantExpr(e.Y)
+	}
+	return false
+}
+
+// constantDeltas returns the changes applicable to lit. Values which are likely
+// to overflow their type when changed (0 - 1, 2^n - 1 + 1) are left alone.
+func constantDeltas(lit *ast.BasicLit) []int64 {
+	value, err := strconv.ParseInt(lit.Value, 0, 64)
+	if err != nil {
+		return nil
+	}
+
+	var result []int64
+	if value > 0 {
+		result = append(result, -1)
+	}
+	if value == 0 || (value > 0 && value&(value+1) != 0) {
+		result = append(result, 1)
+	}
+	return result
+}
+
+// changeConstant returns the value of lit changed by delta.
+func changeConstant(lit *ast.BasicLit, delta int64) string {
+	value, _ := strconv.ParseInt(lit.Value, 0, 64)
+	return strconv.FormatInt(value+delta, 10)
+}
+
+var (
+	objectType = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// cloneNode returns a deep copy of the syntax tree n, sharing only identifier resolution.
+func cloneNode(n ast.Node) ast.Node {
+	return cloneValue(reflect.ValueOf(n)).Interface().(ast.Node)
+}
+
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == objectType || v.Type() == scopeType {
+			return v
+		}
+
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(cloneValue(v.Elem()))
+		return c
+
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return c
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.New(v.Type()).Elem()
+		c.Set(cloneValue(v.Elem()))
+		return c
+	}
+	return v
+}
+
+// mutantState is the daemon's knowledge about one mutant.
+type mutantState struct {
+	File        string `json:"file"`
+	ID          int    `json:"id"`
+	Line        int    `json:"line"`
+	Col         int    `json:"col"`
+	Description string `json:"description"`
+	Status      string `json:"status"` // "", "killed" or "survived"
+}
+
+type mutantKey struct {
+	file string
+	id   int
+}
+
+// mutants holds all reported mutants, protected by countsLock.
+var mutants = make(map[mutantKey]*mutantState)
+
+// mutantName identifies a mutant in FULLCOVER_MUTANT.
+func (m *mutantState) name() string {
+	return fmt.Sprintf("%s#%d", m.File, m.ID)
+}
+
+// collectMutant records a mutant reported by an instrumented program.
+func collectMutant(filename string, id, line, col int, description string) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	key := mutantKey{file: filename, id: id}
+	m := mutants[key]
+	if m == nil || m.Line != line || m.Col != col || m.Description != description {
+		mutants[key] = &mutantState{
+			File:        filename,
+			ID:          id,
+			Line:        line,
+			Col:         col,
+			Description: description,
+		}
+	}
+}
+
+// handleMutants lists all mutants on GET /mutants and records the outcome of
+// a test run on POST /mutants/result with "mutant" (file#id) and "status".
+func handleMutants(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	switch r.URL.Path {
+	case "/mutants":
+		list := []*mutantState{}
+		for _, m := range mutants {
+			list = append(list, m)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].File != list[j].File {
+				return list[i].File < list[j].File
+			}
+			return list[i].ID < list[j].ID
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case "/mutants/result":
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := r.FormValue("status")
+		if status != "killed" && status != "survived" {
+			http.Error(w, "status must be killed or survived", http.StatusBadRequest)
+			return
+		}
+
+		name := r.FormValue("mutant")
+		sep := strings.LastIndex(name, "#")
+		if sep < 0 {
+			http.Error(w, "mutant must be file#id", http.StatusBadRequest)
+			return
+		}
+
+		id, err := strconv.Atoi(name[sep+1:])
+		if err != nil {
+			http.Error(w, "mutant must be file#id", http.StatusBadRequest)
+			return
+		}
+
+		m := mutants[mutantKey{file: name[:sep], id: id}]
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.Status = status
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// survivingMutants returns inline markers for the surviving mutants of
+// filename, see renderSource. The caller must hold countsLock.
+func survivingMutants(filename string) map[int]map[int]string {
+	marks := make(map[int]map[int]string)
+	for key, m := range mutants {
+		if key.file != filename || m.Status != "survived" {
+			continue
+		}
+
+		if marks[m.Line] == nil {
+			marks[m.Line] = make(map[int]string)
+		}
+		marks[m.Line][m.Col] += fmt.Sprintf(`<span style="color: #ff00ff" title="mutant %d survived: %s">&#x25bc;</span>`,
+			m.ID, html.EscapeString(m.Description))
+	}
+	return marks
+}
+
+// runMutate implements "fullcover mutate": it runs a test command once per
+// mutant known to the daemon and reports which of them the tests detected.
+func runMutate(args []string) {
+	flags := flag.NewFlagSet("mutate", flag.ExitOnError)
+	daemonAddress := flags.String("connection", "", "how to reach the sidechannel daemon")
+	timeout := flags.Duration("timeout", time.Minute, "time after which a test run counts as failed")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage of 'go tool fullcover mutate':
+Run a test command against every mutant of -mode=mutate rewritten sources
+	go tool fullcover mutate -connection 'localhost:10001' go test ./...`)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *daemonAddress == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Unmodified, the tests have to pass. This also reports the mutants to the daemon.
+	if err := runTests(flags.Args(), "", *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: tests fail without mutations: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := http.Get("http://" + *daemonAddress + "/mutants")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+
+	var list []*mutantState
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: invalid mutant list: %v\n", err)
+		os.Exit(1)
+	}
+
+	survived := 0
+	for _, m := range list {
+		status := "survived"
+		if runTests(flags.Args(), m.name(), *timeout) != nil {
+			status = "killed"
+		} else {
+			survived++
+		}
+
+		fmt.Printf("%s:%d:%d: %s: %s\n", m.File, m.Line, m.Col, m.Description, status)
+
+		resp, err := http.PostForm("http://"+*daemonAddress+"/mutants/result", url.Values{
+			"mutant": {m.name()},
+			"status": {status},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fmt.Fprintf(os.Stderr, "fullcover: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%d of %d mutants killed\n", len(list)-survived, len(list))
+	if survived > 0 {
+		os.Exit(1)
+	}
+}
+
+// runTests runs command with the given mutant active.
+func runTests(command []string, mutant string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "FULLCOVER_MUTANT="+mutant)
+	if mutant == "" {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	return cmd.Run()
+}
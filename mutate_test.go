@@ -0,0 +1,174 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const terminatingSource = `package main
+
+func classify(n int) string {
+	if n < 0 {
+		return "negative"
+	}
+	if n == 0 {
+		panic("zero")
+	}
+	n++
+	return "positive"
+}
+
+func unreachable(n int) int {
+	switch {
+	case n > 1:
+		return n
+	default:
+		panic("unreachable")
+	}
+}
+
+func must(n int) int {
+	if n > 2 {
+		return n
+	}
+	panic("unreachable")
+}
+
+func main() {
+	classify(1)
+	unreachable(2)
+	must(3)
+}
+`
+
+// TestMutateBuilds rewrites functions ending in panic in mutate mode and
+// checks that the result still compiles.
+func TestMutateBuilds(t *testing.T) {
+	buildMutated(t, terminatingSource)
+}
+
+const namedBoolSource = `package main
+
+type flag bool
+
+func check(v flag, n int) int {
+	if v {
+		n++
+	}
+	if v && n < 3 {
+		n--
+	}
+	for v && n > 10 {
+		n--
+	}
+	return n
+}
+
+func main() {
+	check(true, 1)
+}
+`
+
+// TestMutateNamedBool checks that conditions of named boolean types can be negated.
+func TestMutateNamedBool(t *testing.T) {
+	buildMutated(t, namedBoolSource)
+}
+
+const keyedSource = `package main
+
+func lookup(x int) int {
+	var a [3]int
+	a = [3]int{2: x}
+	m := map[int]int{1: x, 2: x}
+	return a[2] + m[1]
+}
+
+func main() {
+	lookup(1)
+}
+`
+
+// TestMutateKeys checks that keys of composite literals are left alone, as
+// changing them may leave an array's bounds or duplicate a map key.
+func TestMutateKeys(t *testing.T) {
+	buildMutated(t, keyedSource)
+}
+
+const constantSource = `package main
+
+func limits(x int64) int64 {
+	var b byte
+	var y int8
+	var z int64
+	b = 128 + 127
+	y = int8(-128)
+	z = 1 << 62
+	x = x << 63
+	return int64(b) + int64(y) + z + x
+}
+
+func main() {
+	limits(1)
+}
+`
+
+// TestMutateConstantExpressions checks that literals of constant expressions
+// and shift counts are left alone, as changing them may overflow.
+func TestMutateConstantExpressions(t *testing.T) {
+	buildMutated(t, constantSource)
+}
+
+// buildMutated rewrites source in mutate mode and fails t if the result does
+// not compile. It is skipped if the sender package cannot be found.
+func buildMutated(t *testing.T, source string) {
+	if _, err := build.Import(senderPackagePath, "", build.FindOnly); err != nil {
+		t.Skipf("sender package not found: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "fullcover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.go")
+	if err := ioutil.WriteFile(input, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := map[*string]string{
+		mode:         "mutate",
+		connection:   "localhost:10001",
+		output:       filepath.Join(dir, "main", "main.go"),
+		sourceName:   "input.go",
+		coverCall:    senderPackageName + ".ReportCover",
+		blockCall:    senderPackageName + ".ReportBlock",
+		sourceCall:   senderPackageName + ".ReportFile",
+		mutantCall:   senderPackageName + ".Mutant",
+		mutationCall: senderPackageName + ".ReportMutant",
+		testCall:     senderPackageName + ".EnterTest",
+		&inputFile:   input,
+		// annotate renames the sender import if the name is taken.
+		&senderPackageName: senderPackageName,
+	}
+	for flag, value := range settings {
+		defer func(flag *string, saved string) {
+			*flag = saved
+		}(flag, *flag)
+		*flag = value
+	}
+	annotate(input)
+
+	compile := exec.Command("go", "build", "-o", os.DevNull, ".")
+	compile.Dir = filepath.Join(dir, "main")
+	if out, err := compile.CombinedOutput(); err != nil {
+		t.Fatalf("rewritten source does not build: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runReport implements "fullcover report": it prints the coverage per file as
+// collected by the daemon and fails if it is below the thresholds given, or
+// below the per-file coverage recorded in a baseline.
+func runReport(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	daemonAddress := flags.String("connection", "", "how to reach the sidechannel daemon")
+	sessionName := flags.String("session", "", "session to report, default is the current one")
+	minTotal := flags.Float64("min-total", 0, "minimum total statement coverage in percent")
+	minFile := flags.Float64("min-file", 0, "minimum statement coverage of every file in percent")
+	baseline := flags.String("baseline", "", "file of per-file coverage no file may fall below; created if missing")
+	update := flags.Bool("update-baseline", false, "raise the coverage in the baseline to the current one, dropping files not reported")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage of 'go tool fullcover report':
+Print the coverage collected by the daemon, failing below thresholds
+	go tool fullcover report -connection 'localhost:10001' -min-total 80 -baseline coverage-baseline.json`)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *daemonAddress == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	resp, err := http.Get("http://" + *daemonAddress + apiPrefix + "files?session=" + url.QueryEscape(*sessionName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Fprintf(os.Stderr, "fullcover: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		os.Exit(1)
+	}
+
+	var files apiFiles
+	err = json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fullcover: invalid coverage: %v\n", err)
+		os.Exit(1)
+	}
+
+	var previous map[string]float64
+	if *baseline != "" {
+		previous, err = readBaseline(*baseline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var violations []string
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\tstatements\tcoverage\t\n")
+	for _, f := range files.Files {
+		percent := percentage(f.CoveredStatements, f.TotalStatements)
+		fmt.Fprintf(tw, "%s\t%d/%d\t%.2f%%\t\n", f.File, f.CoveredStatements, f.TotalStatements, percent)
+
+		if f.TotalStatements > 0 && percent < *minFile {
+			violations = append(violations, fmt.Sprintf("%s: %.2f%% below minimum of %.2f%%", f.File, percent, *minFile))
+		}
+		if before, ok := previous[f.File]; ok && percent < before {
+			violations = append(violations, fmt.Sprintf("%s: %.2f%% below baseline of %.2f%%", f.File, percent, before))
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, f := range files.Files {
+		reported[f.File] = true
+	}
+	var missing []string
+	for file := range previous {
+		if !reported[file] {
+			missing = append(missing, file)
+		}
+	}
+	sort.Strings(missing)
+	for _, file := range missing {
+		if *update {
+			fmt.Fprintf(os.Stderr, "fullcover: %s: not reported, dropped from baseline\n", file)
+		} else {
+			violations = append(violations, fmt.Sprintf("%s: in baseline but not reported", file))
+		}
+	}
+
+	total := percentage(files.Total.CoveredStatements, files.Total.TotalStatements)
+	fmt.Fprintf(tw, "total\t%d/%d\t%.2f%%\t\n", files.Total.CoveredStatements, files.Total.TotalStatements, total)
+	tw.Flush()
+
+	if total < *minTotal {
+		violations = append(violations, fmt.Sprintf("total: %.2f%% below minimum of %.2f%%", total, *minTotal))
+	}
+
+	for _, violation := range violations {
+		fmt.Fprintf(os.Stderr, "fullcover: %s\n", violation)
+	}
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+
+	if *baseline != "" && (previous == nil || *update) {
+		if err := writeBaseline(*baseline, previous, files.Files); err != nil {
+			fmt.Fprintf(os.Stderr, "fullcover: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// percentage returns the statement coverage in percent, 100 if there are no statements.
+func percentage(coveredStmt, totalStmt int) float64 {
+	return ratio(coveredStmt, totalStmt) * 100
+}
+
+// readBaseline reads the per-file coverage of a baseline, or nil if it does not exist yet.
+func readBaseline(path string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var result map[string]float64
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("baseline %s: %v", path, err)
+	}
+	return result, nil
+}
+
+// writeBaseline stores the coverage of files as baseline, never lowering the
+// coverage of a file in previous. Files of previous not among files are dropped.
+func writeBaseline(path string, previous map[string]float64, files []apiFile) error {
+	result := make(map[string]float64)
+	for _, f := range files {
+		result[f.File] = previous[f.File]
+		if percent := percentage(f.CoveredStatements, f.TotalStatements); percent > result[f.File] {
+			result[f.File] = percent
+		}
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+)
+
+// Minimal subset of the SARIF 2.1.0 object model, just enough to report
+// uncovered blocks as code scanning results.
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+const sarifRuleID = "uncovered-block"
+
+// handleSarif reports every uncovered block as a SARIF result.
+//
+// Query parameters:
+//
+//	session  session to report on (default: the current one)
+//	files    comma separated list of files to restrict the report to (may be repeated)
+//	error    minimum number of statements for a block to be reported as "error" (default 10)
+//	warning  minimum number of statements for a block to be reported as "warning" (default 3)
+//
+// Smaller blocks are reported as "note".
+func handleSarif(w http.ResponseWriter, r *http.Request) {
+	errorStmts, err := intParam(r, "error", 10)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	warningStmts, err := intParam(r, "warning", 3)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var onlyFiles map[string]bool
+	for _, list := range r.URL.Query()["files"] {
+		for _, filename := range strings.Split(list, ",") {
+			if filename == "" {
+				continue
+			}
+
+			if onlyFiles == nil {
+				onlyFiles = make(map[string]bool)
+			}
+			onlyFiles[filename] = true
+		}
+	}
+
+	results := []sarifResult{}
+
+	countsLock.Lock()
+	s := requestedSession(r)
+	if s == nil {
+		countsLock.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+
+	for _, filename := range sortedFilenames() {
+		if onlyFiles != nil && !onlyFiles[filename] {
+			continue
+		}
+
+		lines := strings.Split(sources[filename], "\n")
+
+		for _, b := range sortedBlocks(s.counts[filename]) {
+			if b.count > 0 {
+				continue
+			}
+
+			level := "note"
+			if b.numStmt >= errorStmts {
+				level = "error"
+			} else if b.numStmt >= warningStmts {
+				level = "warning"
+			}
+
+			results = append(results, sarifResult{
+				RuleID: sarifRuleID,
+				Level:  level,
+				Message: sarifMessage{
+					Text: fmt.Sprintf("%d statement(s) never executed", b.numStmt),
+				},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: filename},
+						Region: sarifRegion{
+							StartLine:   b.startLine,
+							StartColumn: utf16Column(lines, b.startLine, b.startCol),
+							EndLine:     b.endLine,
+							EndColumn:   utf16Column(lines, b.endLine, b.endCol),
+						},
+					},
+				}},
+			})
+		}
+	}
+	countsLock.Unlock()
+
+	report := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{
+					Name:           "fullcover",
+					InformationURI: "https://github.com/Drahflow/fullcover",
+					Rules: []sarifRule{{
+						ID:               sarifRuleID,
+						ShortDescription: sarifMessage{Text: "Code block never executed"},
+					}},
+				},
+			},
+			Results: results,
+		}},
+	}
+
+	w.Header().Set("Content-Type", "application/sarif+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Printf("could not write SARIF report: %v", err)
+	}
+}
+
+// utf16Column converts the 1-based byte column used by go/token into the
+// 1-based UTF-16 code unit column SARIF expects by default.
+func utf16Column(lines []string, line, col int) int {
+	if line < 1 || line > len(lines) {
+		return col
+	}
+
+	text := lines[line-1]
+	if col-1 > len(text) {
+		return col
+	}
+
+	units := 1
+	for _, r := range text[:col-1] {
+		units += utf16.RuneLen(r)
+	}
+	return units
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"sort"
+)
+
+// tally counts executions attributed to a name, like a scope (or a test),
+// key is [name][source][startLine][startCol].
+type tally map[string]map[string]map[int]map[int]int
+
+// add counts one execution of the block at line and col of filename for name.
+func (t tally) add(name, filename string, line, col int) {
+	if t[name] == nil {
+		t[name] = make(map[string]map[int]map[int]int)
+	}
+
+	if t[name][filename] == nil {
+		t[name][filename] = make(map[int]map[int]int)
+	}
+
+	if t[name][filename][line] == nil {
+		t[name][filename][line] = make(map[int]int)
+	}
+
+	t[name][filename][line][col]++
+}
+
+// names returns all names with executions, sorted.
+func (t tally) names() []string {
+	var result []string
+	for name := range t {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// view returns a copy of counts where each block's count only includes the
+// executions attributed to name.
+func (t tally) view(name string, counts map[string]map[int]map[int]*block) map[string]map[int]map[int]*block {
+	result := make(map[string]map[int]map[int]*block)
+	for filename, lineCounts := range counts {
+		result[filename] = make(map[int]map[int]*block)
+
+		for line, colCounts := range lineCounts {
+			result[filename][line] = make(map[int]*block)
+
+			for col, b := range colCounts {
+				scoped := *b
+				scoped.count = t[name][filename][line][col]
+				result[filename][line][col] = &scoped
+			}
+		}
+	}
+	return result
+}
+
+// viewCounts returns the counts of s, restricted to scope or else to test
+// unless both are empty.
+func (s *session) viewCounts(scope, test string) map[string]map[int]map[int]*block {
+	switch {
+	case scope != "":
+		return s.scopes.view(scope, s.counts)
+	case test != "":
+		return s.tests.view(test, s.counts)
+	}
+	return s.counts
+}
+
+// viewQuery returns the query string selecting session s and scope or test.
+func viewQuery(s *session, scope, test string) string {
+	query := "session=" + url.QueryEscape(s.name)
+	if scope != "" {
+		query += "&scope=" + url.QueryEscape(scope)
+	}
+	if test != "" {
+		query += "&test=" + url.QueryEscape(test)
+	}
+	return query
+}
+
+// writeScopeLinks lists the scopes of s, linking to page filtered by each of them.
+func writeScopeLinks(w io.Writer, s *session, page, selected string) {
+	names := s.scopes.names()
+	if len(names) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, `  <p>Scopes: `)
+	if selected == "" {
+		fmt.Fprintf(w, `<b>all</b>`)
+	} else {
+		fmt.Fprintf(w, `<a href="%s?%s">all</a>`, page, viewQuery(s, "", ""))
+	}
+
+	for _, name := range names {
+		if name == selected {
+			fmt.Fprintf(w, ` | <b>%s</b>`, html.EscapeString(name))
+		} else {
+			fmt.Fprintf(w, ` | <a href="%s?%s">%s</a>`, page, viewQuery(s, name, ""), html.EscapeString(name))
+		}
+	}
+	fmt.Fprintf(w, "</p>\n")
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 by Drahflow. Use of this source code is governed by a
+// BSD-style license that can be found in the LICENSE file.
+
+package sender
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// checkpointPrefix makes checkpoint ids unique across processes
+var checkpointPrefix = strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(os.Getpid())
+var checkpointCounter uint64
+
+// checkpoint sends a marker through the coverage stream and returns its id,
+// or "" if there is no connection to the daemon yet.
+func checkpoint() string {
+	if con == nil {
+		return ""
+	}
+
+	id := fmt.Sprintf("%s-%d", checkpointPrefix, atomic.AddUint64(&checkpointCounter, 1))
+	chunk := fmt.Sprintf("K%d:%s", len(id), id)
+	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
+	return id
+}
+
+// firstsBetween asks the daemon how many blocks were executed for the first
+// time between two checkpoints.
+func firstsBetween(from, to string) (int, error) {
+	resp, err := http.PostForm("http://"+receiverAddress+"/checkpoints", url.Values{
+		"from": {from},
+		"to":   {to},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("daemon: %s", resp.Status)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(body)))
+}
+
+// Capture wraps next, storing every request whose handling executed a block
+// for the first time (as far as the daemon knows) into dir, named by its hash.
+// The raw requests can be replayed as a regression suite or fuzzing corpus.
+// Blocks executed by concurrently handled requests may be attributed to either.
+func Capture(next http.Handler, dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		before := checkpoint()
+		defer func() {
+			// Deferred, so requests which make the handler panic are captured, too.
+			after := checkpoint()
+			if before == "" || after == "" {
+				return
+			}
+
+			go func() {
+				firsts, err := firstsBetween(before, after)
+				if err != nil || firsts == 0 {
+					return
+				}
+
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return
+				}
+				ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%x", sha1.Sum(raw))), raw, 0644)
+			}()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
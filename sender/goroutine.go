@@ -0,0 +1,16 @@
+// Copyright 2016 by Drahflow. Use of this source code is governed by a
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build amd64 || arm64
+
+package sender
+
+// getg returns the address of the runtime's descriptor of the calling
+// goroutine. It is reused for another goroutine only after this one exited.
+func getg() uintptr
+
+// goroutineKey identifies the calling goroutine among the running ones. It is
+// called on every execution while a binding exists, so it must be cheap.
+func goroutineKey() uintptr {
+	return getg()
+}
@@ -0,0 +1,10 @@
+// Copyright 2016 by Drahflow. Use of this source code is governed by a
+// BSD-style license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func getg() uintptr
+TEXT ·getg(SB), NOSPLIT, $0-8
+	MOVD g, R0
+	MOVD R0, ret+0(FP)
+	RET
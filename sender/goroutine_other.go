@@ -0,0 +1,27 @@
+// Copyright 2016 by Drahflow. Use of this source code is governed by a
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build !amd64 && !arm64
+
+package sender
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goroutineKey identifies the calling goroutine among the running ones by the
+// id parsed from its stack trace. This is much slower than reading the
+// goroutine's descriptor, which is only implemented for some architectures.
+func goroutineKey() uintptr {
+	var buf [64]byte
+	trace := buf[:runtime.Stack(buf[:], false)]
+	trace = bytes.TrimPrefix(trace, []byte("goroutine "))
+	if end := bytes.IndexByte(trace, ' '); end >= 0 {
+		trace = trace[:end]
+	}
+
+	id, _ := strconv.ParseUint(string(trace), 10, 64)
+	return uintptr(id)
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 by Drahflow. Use of this source code is governed by a
+// BSD-style license that can be found in the LICENSE file.
+
+package sender
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+type scopeKey struct{}
+
+// WithScope returns a context carrying the name executions should be attributed to.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFrom returns the scope carried by ctx, or "".
+func ScopeFrom(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// binding is what the executions of a goroutine are attributed to
+type binding struct {
+	scope string
+	test  string
+}
+
+// bindings maps goroutines, as identified by goroutineKey, to their binding
+var bindings = make(map[uintptr]binding)
+var bindingsLock sync.RWMutex
+
+// bindingsActive counts the entries of bindings, so unscoped programs need not look them up
+var bindingsActive int32
+
+// Enter attributes the executions of the calling goroutine to the scope carried
+// by ctx until the returned function is called. Goroutines started meanwhile
+// must call Enter themselves:
+//
+//	defer sender.Enter(ctx)()
+func Enter(ctx context.Context) (leave func()) {
+	scope := ScopeFrom(ctx)
+	if scope == "" {
+		return func() {}
+	}
+
+	return bind(func(b *binding) { b.scope = scope })
+}
+
+// EnterTest attributes the executions of the calling goroutine to the test t
+// (usually a *testing.T) until the returned function is called. Instrumented
+// test functions call it on entry.
+func EnterTest(t interface{ Name() string }) (leave func()) {
+	name := t.Name()
+	return bind(func(b *binding) { b.test = name })
+}
+
+// bind changes the binding of the calling goroutine until the returned
+// function is called, which restores the previous binding.
+func bind(change func(b *binding)) (leave func()) {
+	id := goroutineKey()
+
+	bindingsLock.Lock()
+	previous, nested := bindings[id]
+	next := previous
+	change(&next)
+	bindings[id] = next
+	bindingsLock.Unlock()
+	if !nested {
+		atomic.AddInt32(&bindingsActive, 1)
+	}
+
+	return func() {
+		bindingsLock.Lock()
+		if nested {
+			bindings[id] = previous
+		} else {
+			delete(bindings, id)
+		}
+		bindingsLock.Unlock()
+		if !nested {
+			atomic.AddInt32(&bindingsActive, -1)
+		}
+	}
+}
+
+// currentBinding returns what the calling goroutine's executions are attributed to.
+func currentBinding() binding {
+	if atomic.LoadInt32(&bindingsActive) == 0 {
+		return binding{}
+	}
+
+	id := goroutineKey()
+
+	bindingsLock.RLock()
+	defer bindingsLock.RUnlock()
+	return bindings[id]
+}
+
+// Scoped attributes the executions of each request served by next to a scope
+// named by the request header of the given name or, if the header is missing
+// or no name was given, by the method and path of the request. The daemon
+// tallies every scope separately, so the header should not be unique per
+// request:
+//
+//	http.Handle("/", sender.Scoped(mux, "X-Scenario"))
+func Scoped(next http.Handler, header string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := ""
+		if header != "" {
+			scope = r.Header.Get(header)
+		}
+		if scope == "" {
+			scope = r.Method + " " + r.URL.Path
+		}
+
+		ctx := WithScope(r.Context(), scope)
+		defer Enter(ctx)()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
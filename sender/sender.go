@@ -6,22 +6,66 @@ package sender
 import (
 	"net"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"net/http"
+	"net/url"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+	"os"
+	"strings"
 )
 
 var con net.Conn
 
+// receiverAddress is where con is connected to
+var receiverAddress string
+
+// paused is non-zero while executions should not be reported
+var paused int32
+
+// pending holds the chunks sent before con was connected, as only the
+// instrumented code knows where to connect to
+var pending []string
+var pendingLock sync.Mutex
+
 func initConnection(receiver string) {
 	if con != nil {
 		return
 	}
 
-	var err error
-	con, err = net.Dial("tcp", receiver)
+	connection, err := net.Dial("tcp", receiver)
 	if err != nil {
 		panic(err)
 	}
+	receiverAddress = receiver
 
-	fmt.Fprintf(con, "POST /coverage HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n")
+	fmt.Fprintf(connection, "POST /coverage HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n")
+
+	pendingLock.Lock()
+	for _, chunk := range pending {
+		fmt.Fprintf(connection, "%x\r\n%s\r\n", len(chunk), chunk)
+	}
+	pending = nil
+	con = connection
+	pendingLock.Unlock()
+}
+
+// sendOnConnection sends chunk to the daemon or, if the program did not
+// connect to it yet, as soon as it does.
+func sendOnConnection(chunk string) {
+	pendingLock.Lock()
+	if con == nil {
+		pending = append(pending, chunk)
+		pendingLock.Unlock()
+		return
+	}
+	pendingLock.Unlock()
+
+	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
 }
 
 func ReportFile(receiver string, filename string, source string) {
@@ -38,8 +82,195 @@ func ReportBlock(receiver string, filename string, startLine int, startCol int,
 	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
 }
 func ReportCover(receiver string, filename string, startLine int, startCol int, endLine int, endCol int, numStmt int) {
+	if atomic.LoadInt32(&paused) != 0 {
+		return
+	}
+
 	initConnection(receiver)
 
 	chunk := fmt.Sprintf("C%d:%s%d:%d:%d:%d:%d:", len(filename), filename, startLine, startCol, endLine, endCol, numStmt)
+	switch b := currentBinding(); {
+	case b.test != "":
+		chunk = fmt.Sprintf("T%d:%s%d:%s%s", len(b.test), b.test, len(b.scope), b.scope, chunk[1:])
+	case b.scope != "":
+		chunk = fmt.Sprintf("S%d:%s%s", len(b.scope), b.scope, chunk[1:])
+	}
 	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
 }
+
+
+// Mark attributes all following executions to the named session of the daemon,
+// starting it if necessary.
+func Mark(session string) {
+	sendOnConnection(fmt.Sprintf("M%d:%s", len(session), session))
+}
+
+// MarkOutcome labels the session executions are attributed to as passing or
+// failing, for the daemon to locate faults by the blocks failing sessions execute.
+func MarkOutcome(passed bool) {
+	outcome := "fail"
+	if passed {
+		outcome = "pass"
+	}
+
+	sendOnConnection(fmt.Sprintf("O%d:%s", len(outcome), outcome))
+}
+
+// Pause stops reporting executions until Resume is called.
+func Pause() {
+	atomic.StoreInt32(&paused, 1)
+}
+
+// Resume continues reporting executions after Pause.
+func Resume() {
+	atomic.StoreInt32(&paused, 0)
+}
+
+func ReportInjectionSite(receiver string, filename string, line int, col int, call string) {
+	initConnection(receiver)
+	watchArmed(receiver)
+
+	chunk := fmt.Sprintf("E%d:%s%d:%d:%d:%s", len(filename), filename, line, col, len(call), call)
+	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
+}
+
+type injectionSite struct {
+	filename string
+	line     int
+	col      int
+}
+
+// armed holds the injection sites the daemon wants errors injected at.
+var armed = make(map[injectionSite]bool)
+var armedLock sync.RWMutex
+var armedPolling sync.Once
+
+// armedClient fetches the armed sites. The first fetch delays the program's
+// start, so a daemon which does not answer must not block it for long.
+var armedClient = &http.Client{Timeout: 2 * time.Second}
+
+// watchArmed fetches the armed injection sites once, before any error is
+// injected, and then keeps polling for changes in the background.
+func watchArmed(receiver string) {
+	armedPolling.Do(func() {
+		fetchArmed(receiver)
+		go pollArmed(receiver)
+	})
+}
+
+// pollArmed regularly fetches the armed injection sites from the daemon.
+func pollArmed(receiver string) {
+	for {
+		time.Sleep(time.Second)
+		fetchArmed(receiver)
+	}
+}
+
+// fetchArmed replaces the armed injection sites by those of the daemon. They
+// are kept if the daemon cannot be reached.
+func fetchArmed(receiver string) {
+	var sites []struct {
+		File string `json:"file"`
+		Line int    `json:"line"`
+		Col  int    `json:"col"`
+	}
+
+	resp, err := armedClient.Get("http://" + receiver + "/inject/armed")
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&sites)
+	resp.Body.Close()
+	if err != nil {
+		return
+	}
+
+	newArmed := make(map[injectionSite]bool)
+	for _, s := range sites {
+		newArmed[injectionSite{s.File, s.Line, s.Col}] = true
+	}
+
+	armedLock.Lock()
+	armed = newArmed
+	armedLock.Unlock()
+}
+
+// InjectError returns the error to inject at the call site at line and col of
+// filename, or nil if the site is not armed. The results pointed to are set to
+// their zero values if requested. branchLine and branchCol identify the block
+// handling the error, so the daemon can tell which executions were injected.
+func InjectError(receiver string, filename string, line int, col int, branchLine int, branchCol int, results ...interface{}) error {
+	watchArmed(receiver)
+
+	armedLock.RLock()
+	isArmed := armed[injectionSite{filename, line, col}]
+	armedLock.RUnlock()
+
+	if !isArmed {
+		return nil
+	}
+
+	resp, err := http.PostForm("http://"+receiver+"/inject/take", url.Values{
+		"file":       {filename},
+		"line":       {strconv.Itoa(line)},
+		"col":        {strconv.Itoa(col)},
+		"branchLine": {strconv.Itoa(branchLine)},
+		"branchCol":  {strconv.Itoa(branchCol)},
+	})
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var injection struct {
+		Message string `json:"message"`
+		Zero    bool   `json:"zero"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&injection); err != nil {
+		return nil
+	}
+
+	if injection.Zero {
+		for _, result := range results {
+			v := reflect.ValueOf(result).Elem()
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+
+	return errors.New(injection.Message)
+}
+
+func ReportMutant(receiver string, filename string, id int, line int, col int, description string) {
+	initConnection(receiver)
+
+	chunk := fmt.Sprintf("U%d:%s%d:%d:%d:%d:%s", len(filename), filename, id, line, col, len(description), description)
+	fmt.Fprintf(con, "%x\r\n%s\r\n", len(chunk), chunk)
+}
+
+// activeMutant is the mutant selected by FULLCOVER_MUTANT ("file#id"), if any.
+var activeMutant struct {
+	filename string
+	id       int
+}
+var activeMutantOnce sync.Once
+
+// Mutant reports whether mutant id of filename is the active one.
+func Mutant(receiver string, filename string, id int) bool {
+	activeMutantOnce.Do(func() {
+		name := os.Getenv("FULLCOVER_MUTANT")
+		if sep := strings.LastIndex(name, "#"); sep >= 0 {
+			activeMutant.filename = name[:sep]
+			activeMutant.id, _ = strconv.Atoi(name[sep+1:])
+		}
+	})
+
+	return id == activeMutant.id && filename == activeMutant.filename
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// session is a named coverage tally. All sessions know about all reported
+// blocks, but each of them counts executions separately.
+type session struct {
+	name     string
+	started  time.Time
+	counts   map[string]map[int]map[int]*block // key is [source][startLine][startCol]
+	injected map[string]map[int]map[int]int    // executions caused by injected errors, same key
+	scopes   tally                             // executions by scope
+	tests    tally                             // executions by test
+	outcome  string                            // "pass", "fail" or unknown ("")
+}
+
+const defaultSessionName = "default"
+
+// sessions holds all sessions by name, sessionNames in order of creation.
+var sessions = make(map[string]*session)
+var sessionNames []string
+
+// current is the session executions are counted in.
+var current *session
+
+func init() {
+	switchSession(defaultSessionName)
+}
+
+// switchSession makes the named session current, creating it (with all known
+// blocks, but no executions) if it does not exist yet. The caller must hold
+// countsLock (except during initialization).
+func switchSession(name string) *session {
+	s, ok := sessions[name]
+	if !ok {
+		s = &session{
+			name:     name,
+			started:  time.Now(),
+			counts:   make(map[string]map[int]map[int]*block),
+			injected: make(map[string]map[int]map[int]int),
+			scopes:   make(tally),
+			tests:    make(tally),
+		}
+
+		if current != nil {
+			for filename, lineCounts := range current.counts {
+				for _, colCounts := range lineCounts {
+					for _, b := range colCounts {
+						s.addBlock(filename, b.startLine, b.startCol, b.endLine, b.endCol, b.numStmt)
+					}
+				}
+			}
+		}
+
+		sessions[name] = s
+		sessionNames = append(sessionNames, name)
+	}
+
+	current = s
+	return s
+}
+
+// addBlock returns the block of filename starting at the given position,
+// creating it if it was not known yet.
+func (s *session) addBlock(filename string, startLine, startCol, endLine, endCol, numStmt int) *block {
+	if s.counts[filename] == nil {
+		s.counts[filename] = make(map[int]map[int]*block)
+	}
+
+	if s.counts[filename][startLine] == nil {
+		s.counts[filename][startLine] = make(map[int]*block)
+	}
+
+	if s.counts[filename][startLine][startCol] == nil {
+		s.counts[filename][startLine][startCol] = &block{
+			startLine: startLine,
+			startCol:  startCol,
+			endLine:   endLine,
+			endCol:    endCol,
+			numStmt:   numStmt,
+			count:     0,
+		}
+	}
+
+	return s.counts[filename][startLine][startCol]
+}
+
+// reset forgets all executions counted in the session.
+func (s *session) reset() {
+	for _, lineCounts := range s.counts {
+		for _, colCounts := range lineCounts {
+			for _, b := range colCounts {
+				b.count = 0
+			}
+		}
+	}
+	s.injected = make(map[string]map[int]map[int]int)
+	s.scopes = make(tally)
+	s.tests = make(tally)
+	s.started = time.Now()
+}
+
+// requestedSession returns the session selected by the "session" query
+// parameter, the current one if there is none, or nil if it does not exist.
+// The caller must hold countsLock.
+func requestedSession(r *http.Request) *session {
+	name := r.URL.Query().Get("session")
+	if name == "" {
+		return current
+	}
+
+	return sessions[name]
+}
+
+// handleSessions lists and compares all sessions on GET /sessions and
+// starts (or continues) a named session on POST /sessions/<name>.
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+
+	if name == "" {
+		handleSessionList(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "use POST to start a session", http.StatusMethodNotAllowed)
+		return
+	}
+
+	countsLock.Lock()
+	switchSession(name)
+	countsLock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "session %s started\n", name)
+}
+
+// handleReset clears all executions of the current (or the requested) session.
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST to reset a session", http.StatusMethodNotAllowed)
+		return
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.reset()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "session %s reset\n", s.name)
+}
+
+// handleSessionList shows the statement coverage of each file side by side for all sessions.
+func handleSessionList(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <table border="1">
+	<tr><th>file</th>`)
+	for _, name := range sessionNames {
+		marker := ""
+		if sessions[name] == current {
+			marker = " (current)"
+		}
+		if sessions[name].outcome != "" {
+			marker += " " + html.EscapeString(sessions[name].outcome)
+		}
+
+		fmt.Fprintf(w, `<th><a href="/?session=%s">%s</a>%s<br>since %s</th>`,
+			url.QueryEscape(name), html.EscapeString(name), marker, sessions[name].started.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "</tr>\n")
+
+	allCovered := make([]int, len(sessionNames))
+	allTotal := make([]int, len(sessionNames))
+
+	for _, filename := range sortedFilenames() {
+		fmt.Fprintf(w, `	<tr><td>%s</td>`, html.EscapeString(filename))
+		for i, name := range sessionNames {
+			coveredStmt, totalStmt := statementCoverage(sessions[name].counts[filename])
+			allCovered[i] += coveredStmt
+			allTotal[i] += totalStmt
+
+			fmt.Fprintf(w, `<td><a href="%s?session=%s">%s</a></td>`,
+				html.EscapeString(fileURL("/", filename)), url.QueryEscape(name), formatCoverage(coveredStmt, totalStmt))
+		}
+		fmt.Fprintf(w, "</tr>\n")
+	}
+
+	fmt.Fprintf(w, `	<tr><th>total</th>`)
+	for i := range sessionNames {
+		fmt.Fprintf(w, `<th>%s</th>`, formatCoverage(allCovered[i], allTotal[i]))
+	}
+	fmt.Fprintf(w, `</tr>
+  </table>
+</body></html>
+`)
+}
+
+// formatCoverage renders a statement coverage ratio for humans.
+func formatCoverage(coveredStmt, totalStmt int) string {
+	if totalStmt == 0 {
+		return "no statements"
+	}
+
+	return fmt.Sprintf("%3.2f%% %d/%d", float32(coveredStmt)/float32(totalStmt)*100, coveredStmt, totalStmt)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Outcomes a session can be labelled with.
+const (
+	passed = "pass"
+	failed = "fail"
+)
+
+// handleOutcome labels the current (or the requested) session as passing or
+// failing on POST /outcome?outcome=pass|fail.
+func handleOutcome(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST to label a session", http.StatusMethodNotAllowed)
+		return
+	}
+
+	outcome := r.FormValue("outcome")
+	if !validOutcome(outcome) {
+		http.Error(w, "outcome must be pass or fail", http.StatusBadRequest)
+		return
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	// The session is read like the outcome, so both may be sent as a form.
+	s := current
+	if name := r.FormValue("session"); name != "" {
+		s = sessions[name]
+	}
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.outcome = outcome
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "session %s labelled %q\n", s.name, outcome)
+}
+
+// validOutcome reports whether outcome is one a session can be labelled with,
+// including none.
+func validOutcome(outcome string) bool {
+	return outcome == passed || outcome == failed || outcome == ""
+}
+
+// spectrum counts in how many passing and failing sessions a block was executed.
+type spectrum struct {
+	passed, failed int
+}
+
+// formulas compute the suspiciousness of a block from its spectrum and the
+// total number of passing and failing sessions.
+var formulas = map[string]func(s spectrum, totalPassed, totalFailed int) float64{
+	"ochiai": func(s spectrum, totalPassed, totalFailed int) float64 {
+		if s.failed == 0 {
+			return 0
+		}
+		return float64(s.failed) / math.Sqrt(float64(totalFailed*(s.failed+s.passed)))
+	},
+	"tarantula": func(s spectrum, totalPassed, totalFailed int) float64 {
+		if s.failed == 0 {
+			return 0
+		}
+
+		failRatio := float64(s.failed) / float64(totalFailed)
+		passRatio := 0.0
+		if totalPassed > 0 {
+			passRatio = float64(s.passed) / float64(totalPassed)
+		}
+		return failRatio / (failRatio + passRatio)
+	},
+	"dstar": func(s spectrum, totalPassed, totalFailed int) float64 {
+		denominator := float64(s.passed + totalFailed - s.failed)
+		if denominator == 0 {
+			if s.failed == 0 {
+				return 0
+			}
+			return math.Inf(1)
+		}
+		return float64(s.failed*s.failed) / denominator
+	},
+}
+
+// suspiciousness scores every block by formula over the labelled sessions.
+// The caller must hold countsLock.
+func suspiciousness(formula string) map[blockKey]float64 {
+	spectra := make(map[blockKey]spectrum)
+	totalPassed, totalFailed := 0, 0
+
+	for _, name := range sessionNames {
+		s := sessions[name]
+		if s.outcome != passed && s.outcome != failed {
+			continue
+		}
+
+		if s.outcome == passed {
+			totalPassed++
+		} else {
+			totalFailed++
+		}
+
+		for filename, lineCounts := range s.counts {
+			for line, colCounts := range lineCounts {
+				for col, b := range colCounts {
+					if b.count == 0 {
+						continue
+					}
+
+					key := blockKey{file: filename, line: line, col: col}
+					sp := spectra[key]
+					if s.outcome == passed {
+						sp.passed++
+					} else {
+						sp.failed++
+					}
+					spectra[key] = sp
+				}
+			}
+		}
+	}
+
+	scores := make(map[blockKey]float64)
+	if totalFailed == 0 {
+		return scores
+	}
+
+	for key, sp := range spectra {
+		scores[key] = formulas[formula](sp, totalPassed, totalFailed)
+	}
+	return scores
+}
+
+// requestedFormula returns the formula selected by the "formula" query
+// parameter, defaulting to ochiai, or "" if it is unknown.
+func requestedFormula(r *http.Request) string {
+	formula := r.URL.Query().Get("formula")
+	if formula == "" {
+		return "ochiai"
+	}
+
+	if _, ok := formulas[formula]; !ok {
+		return ""
+	}
+	return formula
+}
+
+// handleSuspicious ranks all blocks executed in failing sessions by their
+// suspiciousness, computed by the requested formula from the passing and failing sessions.
+func handleSuspicious(w http.ResponseWriter, r *http.Request) {
+	formula := requestedFormula(r)
+	if formula == "" {
+		http.Error(w, "formula must be ochiai, tarantula or dstar", http.StatusBadRequest)
+		return
+	}
+
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	scores := suspiciousness(formula)
+	var keys []blockKey
+	for key, score := range scores {
+		if score > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		if keys[i].file != keys[j].file {
+			return keys[i].file < keys[j].file
+		}
+		if keys[i].line != keys[j].line {
+			return keys[i].line < keys[j].line
+		}
+		return keys[i].col < keys[j].col
+	})
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>Blocks by %s suspiciousness (<a href="/suspicious?formula=ochiai">ochiai</a>, <a href="/suspicious?formula=tarantula">tarantula</a>, <a href="/suspicious?formula=dstar">dstar</a>)</p>
+  <table border="1">
+	<tr><th>block</th><th>score</th></tr>
+`, formula)
+	for _, key := range keys {
+		fmt.Fprintf(w, "\t<tr><td><a href=\"%s\">%s:%d.%d</a></td><td>%.4f</td></tr>\n",
+			html.EscapeString(fileURL("/", key.file)+"?formula="+url.QueryEscape(formula)), html.EscapeString(key.file), key.line, key.col, scores[key])
+	}
+	fmt.Fprintf(w, `
+  </table>
+</body></html>
+`)
+}
+
+// suspiciousColor styles a block from no background (not suspicious) to red
+// (as suspicious as the most suspicious finite score max, or infinitely so).
+func suspiciousColor(b *block, score, max float64) string {
+	if b == nil {
+		return changeColor(-1)
+	}
+
+	heat := 0.0
+	switch {
+	case math.IsInf(score, 1):
+		heat = 1
+	case max > 0:
+		heat = score / max
+	}
+
+	return fmt.Sprintf(`</span><span style="background-color: #%02x0000" title="%.4f">`, int(heat*0x80), score)
+}
+
+// writeSuspiciousSource shows filename with the counts of session s, heat-mapped
+// by the suspiciousness of its blocks, computed by the requested formula. The
+// caller must hold countsLock.
+func writeSuspiciousSource(w http.ResponseWriter, r *http.Request, s *session, filename string) {
+	formula := requestedFormula(r)
+	if formula == "" {
+		http.Error(w, "formula must be ochiai, tarantula or dstar", http.StatusBadRequest)
+		return
+	}
+
+	// Infinite scores get full heat anyway, so scale by the largest finite one.
+	scores := suspiciousness(formula)
+	max := 0.0
+	for _, score := range scores {
+		if !math.IsInf(score, 1) {
+			max = math.Max(max, score)
+		}
+	}
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body style="background-color: black; color: white;">
+  <p>%s by %s suspiciousness (<a href="/suspicious?formula=%s">ranking</a>)</p>
+`, html.EscapeString(filename), formula, url.QueryEscape(formula))
+	renderSource(w, sources[filename], s.counts[filename], func(b *block) string {
+		if b == nil {
+			return changeColor(-1)
+		}
+		return suspiciousColor(b, scores[blockKey{file: filename, line: b.startLine, col: b.startCol}], max)
+	}, nil)
+	fmt.Fprintf(w, `
+</body></html>
+`)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"go/scanner"
+	"go/token"
+)
+
+// tokenClasses returns for every byte of source the CSS class of the token
+// it belongs to, "" for identifiers, operators and white space.
+func tokenClasses(source string) []string {
+	classes := make([]string, len(source))
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(source))
+
+	var s scanner.Scanner
+	// Sources may not be Go (or not valid Go), so errors only end highlighting early.
+	s.Init(file, []byte(source), func(token.Position, string) {}, scanner.ScanComments)
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return classes
+		}
+
+		class := ""
+		switch {
+		case tok == token.COMMENT:
+			class = "comment"
+		case tok == token.STRING || tok == token.CHAR:
+			class = "string"
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			class = "number"
+		case tok.IsKeyword():
+			class = "keyword"
+		}
+		if class == "" {
+			continue
+		}
+
+		start := file.Offset(pos)
+		length := len(lit)
+		if tok.IsKeyword() {
+			length = len(tok.String())
+		}
+		for i := start; i < start+length && i < len(classes); i++ {
+			classes[i] = class
+		}
+	}
+}
+
+const syntaxStyle = `
+<style>
+  pre { color: #d4d4d4; }
+  .keyword { color: #569cd6; }
+  .string { color: #ce9178; }
+  .number { color: #b5cea8; }
+  .comment { color: #6a9955; }
+</style>
+`
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// addTestEntries makes every function taking a *testing.T attribute the
+// executions of its goroutine to that test, which includes subtests run as
+// function literals.
+func (f *File) addTestEntries() {
+	testingName := ""
+	for _, s := range f.astFile.Imports {
+		if unquote(s.Path.Value) != "testing" {
+			continue
+		}
+
+		testingName = "testing"
+		if s.Name != nil {
+			testingName = s.Name.Name
+		}
+	}
+	if testingName == "" || testingName == "_" || testingName == "." {
+		return
+	}
+
+	ast.Inspect(f.astFile, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncDecl:
+			if n.Body != nil {
+				n.Body.List = f.enterTest(n.Type, n.Body.List, testingName)
+			}
+		case *ast.FuncLit:
+			n.Body.List = f.enterTest(n.Type, n.Body.List, testingName)
+		}
+		return true
+	})
+}
+
+// enterTest prepends
+//
+//	defer EnterTest(t)()
+//
+// to body if the function has a parameter t of type *testing.T.
+func (f *File) enterTest(fn *ast.FuncType, body []ast.Stmt, testingName string) []ast.Stmt {
+	for _, field := range fn.Params.List {
+		star, ok := field.Type.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+
+		sel, ok := star.X.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "T" {
+			continue
+		}
+
+		pkg, ok := sel.X.(*ast.Ident)
+		if !ok || pkg.Name != testingName {
+			continue
+		}
+
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				continue
+			}
+
+			enter := &ast.DeferStmt{
+				Call: &ast.CallExpr{
+					Fun: &ast.CallExpr{
+						Fun:  ast.NewIdent(*testCall),
+						Args: []ast.Expr{ast.NewIdent(name.Name)},
+					},
+				},
+			}
+			return append([]ast.Stmt{enter}, body...)
+		}
+	}
+	return body
+}
+
+// owners returns for every block the sorted names it was executed by.
+func (t tally) owners() map[blockKey][]string {
+	result := make(map[blockKey][]string)
+	for _, name := range t.names() {
+		for filename, lineCounts := range t[name] {
+			for line, colCounts := range lineCounts {
+				for col := range colCounts {
+					key := blockKey{file: filename, line: line, col: col}
+					result[key] = append(result[key], name)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// testsColor styles a block like countColor, naming the tests executing it in the title.
+func testsColor(b *block, max int, tests []string) string {
+	style := countColor(b, max)
+	if len(tests) == 0 {
+		return style
+	}
+
+	title := fmt.Sprintf("%d: %s", b.count, strings.Join(tests, ", "))
+	return strings.Replace(style, fmt.Sprintf(`title="%d"`, b.count), fmt.Sprintf(`title="%s"`, html.EscapeString(title)), 1)
+}
+
+// writeTestLink names the test the view is restricted to, if any.
+func writeTestLink(w io.Writer, s *session, test string) {
+	if test == "" {
+		return
+	}
+
+	fmt.Fprintf(w, `  <p>Test: %s (<a href="/tests?session=%s">all tests</a>)</p>
+`, html.EscapeString(test), url.QueryEscape(s.name))
+}
+
+// handleTests lists for every test of the requested session how many blocks it
+// executes and which blocks no other test executes.
+func handleTests(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	owners := s.tests.owners()
+	unique := make(map[string][]blockKey)
+	for key, tests := range owners {
+		if len(tests) == 1 {
+			unique[tests[0]] = append(unique[tests[0]], key)
+		}
+	}
+
+	fmt.Fprintf(w, `
+<html><head>
+</head><body>
+  <p>Tests of session %s (<a href="/minimize?session=%s">minimal subset</a>)</p>
+  <table border="1">
+	<tr><th>test</th><th>blocks executed</th><th>uniquely executed blocks</th></tr>
+`, html.EscapeString(s.name), url.QueryEscape(s.name))
+	for _, test := range s.tests.names() {
+		executed := 0
+		for _, lineCounts := range s.tests[test] {
+			for _, colCounts := range lineCounts {
+				executed += len(colCounts)
+			}
+		}
+
+		keys := unique[test]
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].file != keys[j].file {
+				return keys[i].file < keys[j].file
+			}
+			if keys[i].line != keys[j].line {
+				return keys[i].line < keys[j].line
+			}
+			return keys[i].col < keys[j].col
+		})
+
+		var blocks []string
+		for _, key := range keys {
+			blocks = append(blocks, fmt.Sprintf(`<a href="%s">%s:%d.%d</a>`,
+				html.EscapeString(fileURL("/", key.file)+"?"+viewQuery(s, "", test)), html.EscapeString(key.file), key.line, key.col))
+		}
+
+		fmt.Fprintf(w, "\t<tr><td><a href=\"/?%s\">%s</a></td><td>%d</td><td>%s</td></tr>\n",
+			viewQuery(s, "", test), html.EscapeString(test), executed, strings.Join(blocks, "<br>"))
+	}
+	fmt.Fprintf(w, `
+  </table>
+</body></html>
+`)
+}
+
+// testCoverage is the per-test coverage of a session as served on GET /tests/blocks.
+type testCoverage struct {
+	Files []string               `json:"files"` // all sources known to the daemon
+	Tests map[string][]testBlock `json:"tests"`
+}
+
+type testBlock struct {
+	File      string `json:"file"`
+	StartLine int    `json:"startLine"`
+	StartCol  int    `json:"startCol"`
+	EndLine   int    `json:"endLine"`
+	EndCol    int    `json:"endCol"`
+}
+
+// handleTestBlocks serves the blocks executed by each test of the requested session.
+func handleTestBlocks(w http.ResponseWriter, r *http.Request) {
+	countsLock.Lock()
+	defer countsLock.Unlock()
+
+	s := requestedSession(r)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	result := testCoverage{
+		Files: sortedFilenames(),
+		Tests: make(map[string][]testBlock),
+	}
+	for test, fileCounts := range s.tests {
+		blocks := []testBlock{}
+		for filename, lineCounts := range fileCounts {
+			for line, colCounts := range lineCounts {
+				for col := range colCounts {
+					b := lookupBlock(s.counts[filename], line, col)
+					if b == nil {
+						continue
+					}
+
+					blocks = append(blocks, testBlock{
+						File:      filename,
+						StartLine: b.startLine,
+						StartCol:  b.startCol,
+						EndLine:   b.endLine,
+						EndCol:    b.endCol,
+					})
+				}
+			}
+		}
+		result.Tests[test] = blocks
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
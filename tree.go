@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeNode is a directory of the index, holding the statement totals of all
+// files below it.
+type treeNode struct {
+	name        string
+	dirs        map[string]*treeNode
+	files       []treeFile
+	coveredStmt int
+	totalStmt   int
+}
+
+type treeFile struct {
+	name        string // full name, as reported
+	coveredStmt int
+	totalStmt   int
+}
+
+// ratio returns the covered fraction of the statements, 1 if there are none.
+func ratio(coveredStmt, totalStmt int) float64 {
+	if totalStmt == 0 {
+		return 1
+	}
+	return float64(coveredStmt) / float64(totalStmt)
+}
+
+// indexFilter selects the files shown in the index.
+type indexFilter struct {
+	sort   string  // "name", "coverage" or "size"
+	filter string  // substring the file name must contain
+	below  float64 // percentage the coverage must be below, if positive
+}
+
+// requestedFilter reads the index filter from the query parameters.
+func requestedFilter(r *http.Request) indexFilter {
+	f := indexFilter{
+		sort:   r.URL.Query().Get("sort"),
+		filter: r.URL.Query().Get("filter"),
+	}
+	f.below, _ = strconv.ParseFloat(r.URL.Query().Get("below"), 64)
+	return f
+}
+
+// buildTree arranges the files passing filter into a tree of their directories.
+func buildTree(counts map[string]map[int]map[int]*block, filter indexFilter) *treeNode {
+	root := &treeNode{dirs: make(map[string]*treeNode)}
+
+	for filename := range sources {
+		if !strings.Contains(filename, filter.filter) {
+			continue
+		}
+
+		coveredStmt, totalStmt := statementCoverage(counts[filename])
+		if filter.below > 0 && (totalStmt == 0 || ratio(coveredStmt, totalStmt)*100 >= filter.below) {
+			continue
+		}
+
+		node := root
+		node.coveredStmt += coveredStmt
+		node.totalStmt += totalStmt
+
+		parts := strings.Split(filename, "/")
+		for _, dir := range parts[:len(parts)-1] {
+			if node.dirs[dir] == nil {
+				node.dirs[dir] = &treeNode{name: dir, dirs: make(map[string]*treeNode)}
+			}
+			node = node.dirs[dir]
+			node.coveredStmt += coveredStmt
+			node.totalStmt += totalStmt
+		}
+		node.files = append(node.files, treeFile{filename, coveredStmt, totalStmt})
+	}
+	return root
+}
+
+// less orders two entries of the tree as the filter requests.
+func (f indexFilter) less(nameA string, coveredA, totalA int, nameB string, coveredB, totalB int) bool {
+	switch f.sort {
+	case "coverage":
+		if ra, rb := ratio(coveredA, totalA), ratio(coveredB, totalB); ra != rb {
+			return ra < rb
+		}
+	case "size":
+		if totalA != totalB {
+			return totalA > totalB
+		}
+	}
+	return nameA < nameB
+}
+
+// writeTree renders the directories and files of node as nested lists,
+// directories first, linking each file to link(name).
+func writeTree(w io.Writer, node *treeNode, filter indexFilter, link func(filename string) string) {
+	var dirs []*treeNode
+	for _, dir := range node.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return filter.less(dirs[i].name, dirs[i].coveredStmt, dirs[i].totalStmt, dirs[j].name, dirs[j].coveredStmt, dirs[j].totalStmt)
+	})
+	sort.Slice(node.files, func(i, j int) bool {
+		a, b := node.files[i], node.files[j]
+		return filter.less(a.name, a.coveredStmt, a.totalStmt, b.name, b.coveredStmt, b.totalStmt)
+	})
+
+	fmt.Fprintf(w, "<ul>\n")
+	for _, dir := range dirs {
+		fmt.Fprintf(w, "<li><details open><summary>%s/ (%s)</summary>\n", html.EscapeString(dir.name), formatCoverage(dir.coveredStmt, dir.totalStmt))
+		writeTree(w, dir, filter, link)
+		fmt.Fprintf(w, "</details></li>\n")
+	}
+	for _, file := range node.files {
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a> (%s)</li>\n",
+			html.EscapeString(link(file.name)), html.EscapeString(file.name[strings.LastIndex(file.name, "/")+1:]), formatCoverage(file.coveredStmt, file.totalStmt))
+	}
+	fmt.Fprintf(w, "</ul>\n")
+}
+
+// writeFilterForm renders the form changing the filter of the index, keeping
+// the other parameters of the view.
+func writeFilterForm(w io.Writer, r *http.Request, filter indexFilter) {
+	fmt.Fprintf(w, `  <form action="/">
+`)
+	for _, name := range []string{"session", "scope", "test"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			fmt.Fprintf(w, `    <input type="hidden" name="%s" value="%s">
+`, name, html.EscapeString(value))
+		}
+	}
+
+	below := ""
+	if filter.below > 0 {
+		below = strconv.FormatFloat(filter.below, 'f', -1, 64)
+	}
+
+	fmt.Fprintf(w, `    file name contains <input name="filter" value="%s">
+    coverage below <input name="below" size="4" value="%s">%%
+    sort by <select name="sort">`, html.EscapeString(filter.filter), below)
+	for _, order := range []string{"name", "coverage", "size"} {
+		selected := ""
+		if order == filter.sort {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option%s>%s</option>`, selected, order)
+	}
+	fmt.Fprintf(w, `</select>
+    <input type="submit" value="show">
+  </form>
+`)
+}